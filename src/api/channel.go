@@ -0,0 +1,284 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"sync"
+
+	"github.com/cymony/cryptomony/utils"
+)
+
+// Channel message layout:
+//
+//	epoch[4]
+//	seq[8]
+//	ciphertext
+//
+// epoch counts the rekeyings of the sending direction and seq the messages sent since.
+// Both are big-endian and authenticated together with the additional data of the caller.
+// The nonce is concat(zeroes(4), seq).
+const (
+	channelHeaderLen = 4 + 8
+	channelKeyLen    = 32
+	// channelMaxSeq is the number of messages after which a direction must be rekeyed.
+	channelMaxSeq = 1 << 32
+	// channelMaxRekeys is the number of times a direction can be rekeyed between two messages,
+	// and so the number of epochs the receiving direction ratchets forward at once.
+	channelMaxRekeys = 16
+)
+
+var (
+	labelChannel         = "cryptomonyjs-opaque Channel"
+	labelClientToServer  = "ClientToServer"
+	labelServerToClient  = "ServerToClient"
+	labelChannelRekey    = "Rekey"
+	errChannelNotInit    = newError(codeNotInitialized, "channel must be initialized first")
+	errChannelRekey      = newError(codeLimitExceeded, "channel must be rekeyed before sending more messages")
+	errChannelMaxRekeys  = newError(codeLimitExceeded, "channel must send a message before rekeying again")
+	errChannelAuth       = newError(codeAuthFailed, "message authentication failed")
+	errChannelOutOfOrder = newError(codeOutOfSequence, "message replayed, reordered or dropped")
+)
+
+// channelRole is the side of the login a channel belongs to.
+type channelRole string
+
+const (
+	roleClient channelRole = "client"
+	roleServer channelRole = "server"
+)
+
+// channelDirection is the key state of one direction of a channel.
+type channelDirection struct {
+	key     []byte
+	aead    cipher.AEAD
+	epoch   uint32
+	seq     uint64
+	rekeyed int // rekeys since the last message, only counted when sending
+}
+
+func (cd *channelDirection) setKey(key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	wipeBytes(cd.key)
+	cd.key = key
+	cd.aead = aead
+
+	return nil
+}
+
+// ratchet replaces the key with one derived from it and starts a new epoch.
+func (cd *channelDirection) ratchet(expand func(prk, info []byte, length int) []byte) error {
+	if err := cd.setKey(expand(cd.key, []byte(labelChannelRekey), channelKeyLen)); err != nil {
+		return err
+	}
+
+	cd.epoch++
+	cd.seq = 0
+
+	return nil
+}
+
+// clone returns a copy of the direction that does not share its key.
+func (cd *channelDirection) clone() (channelDirection, error) {
+	cp := channelDirection{epoch: cd.epoch, seq: cd.seq}
+	if err := cp.setKey(append([]byte(nil), cd.key...)); err != nil {
+		return channelDirection{}, err
+	}
+
+	return cp, nil
+}
+
+func (cd *channelDirection) wipe() {
+	wipeBytes(cd.key)
+	*cd = channelDirection{}
+}
+
+func (cd *channelDirection) nonce(seq uint64) []byte {
+	nonce := make([]byte, cd.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
+
+// channel encrypts messages between the client and the server with keys derived from the session key.
+// Each direction has its own key and sequence number, so messages must be opened in the order
+// they were sealed and every message is accepted once.
+type channel struct {
+	mu            sync.Mutex
+	isInitialized bool
+	expand        func(prk, info []byte, length int) []byte
+	send          channelDirection
+	recv          channelDirection
+}
+
+func newChannel() *channel {
+	return &channel{isInitialized: false}
+}
+
+// InitializeChannel derives the directional keys from the session key for the given role.
+func (ch *channel) InitializeChannel(suiteName string, sessionKey []byte, role string) error {
+	suiteID, err := strToSuite(suiteName)
+	if err != nil {
+		return err
+	}
+
+	suite := newSuite(suiteID)
+
+	if len(sessionKey) != suite.Nh() {
+		return argError("sessionKey", "session key must be %d bytes", suite.Nh())
+	}
+
+	if role != string(roleClient) && role != string(roleServer) {
+		return argError("role", "role must be one of '%s' or '%s'", roleClient, roleServer)
+	}
+
+	prk := suite.Extract([]byte(labelChannel), sessionKey)
+	defer wipeBytes(prk)
+
+	clientKey := suite.Expand(prk, []byte(labelClientToServer), channelKeyLen)
+	serverKey := suite.Expand(prk, []byte(labelServerToClient), channelKeyLen)
+
+	sendKey, recvKey := clientKey, serverKey
+	if channelRole(role) == roleServer {
+		sendKey, recvKey = serverKey, clientKey
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.send.wipe()
+	ch.recv.wipe()
+
+	if err := ch.send.setKey(sendKey); err != nil {
+		return err
+	}
+
+	if err := ch.recv.setKey(recvKey); err != nil {
+		return err
+	}
+
+	ch.expand = suite.Expand
+	ch.isInitialized = true
+
+	return nil
+}
+
+// Seal encrypts the plaintext into the next message of the sending direction.
+// ad is authenticated but not encrypted, the peer must give the same ad to Open.
+func (ch *channel) Seal(plaintext, ad []byte) ([]byte, error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if !ch.isInitialized {
+		return nil, errChannelNotInit
+	}
+
+	if ch.send.seq >= channelMaxSeq {
+		return nil, errChannelRekey
+	}
+
+	header := make([]byte, channelHeaderLen)
+	binary.BigEndian.PutUint32(header, ch.send.epoch)
+	binary.BigEndian.PutUint64(header[4:], ch.send.seq)
+
+	ciphertext := ch.send.aead.Seal(nil, ch.send.nonce(ch.send.seq), plaintext, utils.Concat(header, ad))
+	ch.send.seq++
+	ch.send.rekeyed = 0
+
+	return utils.Concat(header, ciphertext), nil
+}
+
+// Open decrypts the next message of the receiving direction. A message sealed after
+// the peer rekeyed its sending direction moves the receiving direction to the new key,
+// following up to channelMaxRekeys rekeys at once.
+func (ch *channel) Open(message, ad []byte) ([]byte, error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if !ch.isInitialized {
+		return nil, errChannelNotInit
+	}
+
+	if len(message) < channelHeaderLen+ch.recv.aead.Overhead() {
+		return nil, decodeError("message", errChannelAuth)
+	}
+
+	header := message[:channelHeaderLen]
+	epoch := binary.BigEndian.Uint32(header)
+	seq := binary.BigEndian.Uint64(header[4:])
+
+	// The receiving direction is ratcheted on a copy so that a forged message leaves it as it was.
+	next, err := ch.recv.clone()
+	if err != nil {
+		return nil, err
+	}
+
+	if seq == 0 && epoch > next.epoch && epoch-next.epoch <= channelMaxRekeys {
+		for next.epoch < epoch {
+			if err := next.ratchet(ch.expand); err != nil {
+				next.wipe()
+				return nil, err
+			}
+		}
+	}
+
+	if epoch != next.epoch || seq != next.seq {
+		next.wipe()
+		return nil, errChannelOutOfOrder
+	}
+
+	plaintext, err := next.aead.Open(nil, next.nonce(seq), message[channelHeaderLen:], utils.Concat(header, ad))
+	if err != nil {
+		next.wipe()
+		return nil, errChannelAuth
+	}
+
+	next.seq++
+	ch.recv.wipe()
+	ch.recv = next
+
+	return plaintext, nil
+}
+
+// Rekey replaces the key of the sending direction with one derived from it.
+// The peer moves to the new key when it opens the first message sealed after the rekey,
+// so every message sealed before must be opened first. It fails once the direction has been
+// rekeyed channelMaxRekeys times without sending a message, as the peer would not follow.
+func (ch *channel) Rekey() error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if !ch.isInitialized {
+		return errChannelNotInit
+	}
+
+	if ch.send.rekeyed >= channelMaxRekeys {
+		return errChannelMaxRekeys
+	}
+
+	if err := ch.send.ratchet(ch.expand); err != nil {
+		return err
+	}
+
+	ch.send.rekeyed++
+	return nil
+}
+
+// destroy wipes the channel keys and leaves the channel uninitialized.
+func (ch *channel) destroy() {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.send.wipe()
+	ch.recv.wipe()
+	ch.expand = nil
+	ch.isInitialized = false
+}
@@ -0,0 +1,172 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"syscall/js"
+)
+
+type channelManager struct {
+	channels *registry[*channel]
+}
+
+func newChannelManager() *channelManager {
+	return &channelManager{
+		channels: newRegistry("channel", newChannel),
+	}
+}
+
+func (chm *channelManager) exposeToJS(rootModule js.Value) {
+	rootModule.Set("channel", make(map[string]interface{}))
+	channelModule := rootModule.Get("channel")
+
+	channelModule.Set("newChannel", js.FuncOf(chm.channels.JSCreate))
+	channelModule.Set("initChannel", js.FuncOf(chm.InitChannel))
+	channelModule.Set("seal", js.FuncOf(chm.Seal))
+	channelModule.Set("open", js.FuncOf(chm.Open))
+	channelModule.Set("rekey", js.FuncOf(chm.Rekey))
+	channelModule.Set("destroyChannel", js.FuncOf(chm.channels.JSDestroy))
+	channelModule.Set("destroyAll", js.FuncOf(chm.channels.JSDestroyAll))
+	channelModule.Set("listChannels", js.FuncOf(chm.channels.JSList))
+	channelModule.Set("setMaxChannels", js.FuncOf(chm.channels.JSSetMaxInstances))
+}
+
+// InitChannel derives the channel keys from the session key of a finished login.
+// role is 'client' or 'server' and must differ between the two ends of the channel.
+func (chm *channelManager) InitChannel(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		ch, err := chm.getChannel(inputs, 4)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenSuiteName := inputs[1]
+		chosenSessionKey := inputs[2]
+		chosenRole := inputs[3]
+
+		if err := checkIsString(chosenSuiteName, "suiteName"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		sessionKey, err := copyBytesToGo(chosenSessionKey, "sessionKey")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+		defer wipeBytes(sessionKey)
+
+		if err := checkIsString(chosenRole, "role"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := ch.InitializeChannel(chosenSuiteName.String(), sessionKey, chosenRole.String()); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+		resolve.Invoke()
+	}
+
+	return promiser(runner)
+}
+
+// Seal encrypts the plaintext into the next message for the peer.
+// The optional ad is authenticated but not encrypted.
+func (chm *channelManager) Seal(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		ch, err := chm.channels.lookupBetween(inputs, 2, 3, "channelID")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		plaintext, err := copyBytesToGo(inputs[1], "plaintext")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+		defer wipeBytes(plaintext)
+
+		var ad []byte
+		if len(inputs) == 3 {
+			ad, err = copyOptionalBytesToGo(inputs[2], "ad")
+			if err != nil {
+				rejectErr(reject, err)
+				return
+			}
+		}
+
+		message, err := ch.Seal(plaintext, ad)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke(copyBytesToJS(message))
+	}
+
+	return promiser(runner)
+}
+
+// Open decrypts the next message from the peer. Replayed, reordered
+// and dropped messages are rejected with ERR_OUT_OF_SEQUENCE.
+func (chm *channelManager) Open(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		ch, err := chm.channels.lookupBetween(inputs, 2, 3, "channelID")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		message, err := copyBytesToGo(inputs[1], "message")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		var ad []byte
+		if len(inputs) == 3 {
+			ad, err = copyOptionalBytesToGo(inputs[2], "ad")
+			if err != nil {
+				rejectErr(reject, err)
+				return
+			}
+		}
+
+		plaintext, err := ch.Open(message, ad)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+		defer wipeBytes(plaintext)
+
+		resolve.Invoke(copyBytesToJS(plaintext))
+	}
+
+	return promiser(runner)
+}
+
+// Rekey moves the sending direction of the channel to a new key.
+func (chm *channelManager) Rekey(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		ch, err := chm.getChannel(inputs, 1)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := ch.Rekey(); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+		resolve.Invoke()
+	}
+
+	return promiser(runner)
+}
+
+func (chm *channelManager) getChannel(inputs []js.Value, inputLen int) (*channel, error) {
+	return chm.channels.lookup(inputs, inputLen, "channelID")
+}
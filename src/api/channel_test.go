@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// channelOpen is one message opened by the receiver of a channel test, tampered when tamper is set.
+type channelOpen struct {
+	msg      int
+	tamper   bool
+	wantCode errorCode
+}
+
+// TestChannel seals messages from one side of a channel, rekeying before some of them, and opens
+// them on the other side in the given order.
+func TestChannel(t *testing.T) {
+	tests := []struct {
+		name   string
+		sender channelRole
+		rekeys []int // rekeys of the sender before each message
+		opens  []channelOpen
+	}{
+		{
+			name:   "in order",
+			sender: roleClient,
+			rekeys: []int{0, 0, 0},
+			opens:  []channelOpen{{msg: 0}, {msg: 1}, {msg: 2}},
+		},
+		{
+			name:   "server to client",
+			sender: roleServer,
+			rekeys: []int{0, 1},
+			opens:  []channelOpen{{msg: 0}, {msg: 1}},
+		},
+		{
+			name:   "ratchet one epoch",
+			sender: roleClient,
+			rekeys: []int{0, 1, 0},
+			opens:  []channelOpen{{msg: 0}, {msg: 1}, {msg: 2}},
+		},
+		{
+			name:   "ratchet several epochs",
+			sender: roleClient,
+			rekeys: []int{2, 3, channelMaxRekeys},
+			opens:  []channelOpen{{msg: 0}, {msg: 1}, {msg: 2}},
+		},
+		{
+			name:   "replay",
+			sender: roleClient,
+			rekeys: []int{0, 0},
+			opens:  []channelOpen{{msg: 0}, {msg: 0, wantCode: codeOutOfSequence}, {msg: 1}},
+		},
+		{
+			name:   "replay from an older epoch",
+			sender: roleClient,
+			rekeys: []int{0, 1},
+			opens:  []channelOpen{{msg: 0}, {msg: 1}, {msg: 0, wantCode: codeOutOfSequence}},
+		},
+		{
+			name:   "reorder",
+			sender: roleClient,
+			rekeys: []int{0, 0},
+			opens:  []channelOpen{{msg: 1, wantCode: codeOutOfSequence}, {msg: 0}, {msg: 1}},
+		},
+		{
+			name:   "reorder across epochs",
+			sender: roleClient,
+			rekeys: []int{0, 0, 1},
+			opens:  []channelOpen{{msg: 0}, {msg: 2}, {msg: 1, wantCode: codeOutOfSequence}},
+		},
+		{
+			name:   "dropped",
+			sender: roleClient,
+			rekeys: []int{0, 0, 0},
+			opens:  []channelOpen{{msg: 0}, {msg: 2, wantCode: codeOutOfSequence}},
+		},
+		{
+			name:   "tampered",
+			sender: roleClient,
+			rekeys: []int{0, 0},
+			opens:  []channelOpen{{msg: 0, tamper: true, wantCode: codeAuthFailed}, {msg: 0}, {msg: 1}},
+		},
+		{
+			name:   "tampered after rekey",
+			sender: roleClient,
+			rekeys: []int{0, 1, 0},
+			opens:  []channelOpen{{msg: 0}, {msg: 1, tamper: true, wantCode: codeAuthFailed}, {msg: 1}, {msg: 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		sender, receiver := newTestChannels(t, tt.sender)
+
+		var msgs [][]byte
+
+		for i, rekeys := range tt.rekeys {
+			for j := 0; j < rekeys; j++ {
+				if err := sender.Rekey(); err != nil {
+					t.Fatalf("%s: %v", tt.name, err)
+				}
+			}
+
+			msg, err := sender.Seal([]byte(fmt.Sprint("message ", i)), []byte("ad"))
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+
+			msgs = append(msgs, msg)
+		}
+
+		for i, open := range tt.opens {
+			msg := append([]byte(nil), msgs[open.msg]...)
+			if open.tamper {
+				msg[len(msg)-1] ^= 1
+			}
+
+			got, err := receiver.Open(msg, []byte("ad"))
+			if errorCodeOf(err) != open.wantCode {
+				t.Errorf("%s: open %d: got %v, want code %q", tt.name, i, err, open.wantCode)
+				continue
+			}
+
+			if want := fmt.Sprint("message ", open.msg); err == nil && string(got) != want {
+				t.Errorf("%s: open %d: got %q, want %q", tt.name, i, got, want)
+			}
+		}
+	}
+}
+
+// TestChannelLimits checks the additional data binding and the rekey limit of a channel.
+func TestChannelLimits(t *testing.T) {
+	client, server := newTestChannels(t, roleClient)
+
+	msg, err := client.Seal([]byte("message"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := server.Open(msg, []byte("other ad")); errorCodeOf(err) != codeAuthFailed {
+		t.Errorf("other ad: got %v, want code %q", err, codeAuthFailed)
+	}
+
+	if _, err := client.Open(msg, []byte("ad")); errorCodeOf(err) != codeAuthFailed {
+		t.Errorf("own message: got %v, want code %q", err, codeAuthFailed)
+	}
+
+	for i := 0; i < channelMaxRekeys; i++ {
+		if err := client.Rekey(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := client.Rekey(); errorCodeOf(err) != codeLimitExceeded {
+		t.Errorf("rekey over the limit: got %v, want code %q", err, codeLimitExceeded)
+	}
+
+	client.destroy()
+
+	if _, err := client.Seal([]byte("message"), nil); errorCodeOf(err) != codeNotInitialized {
+		t.Errorf("destroyed: got %v, want code %q", err, codeNotInitialized)
+	}
+}
+
+// newTestChannels returns the channel of sender and the channel of its peer, keyed from the same session key.
+func newTestChannels(t *testing.T, sender channelRole) (*channel, *channel) {
+	t.Helper()
+
+	receiver := roleServer
+	if sender == roleServer {
+		receiver = roleClient
+	}
+
+	sessionKey := bytes.Repeat([]byte{1}, 64)
+
+	send, recv := newChannel(), newChannel()
+
+	if err := send.InitializeChannel("Ristretto255Suite", sessionKey, string(sender)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := recv.InitializeChannel("Ristretto255Suite", sessionKey, string(receiver)); err != nil {
+		t.Fatal(err)
+	}
+
+	return send, recv
+}
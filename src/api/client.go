@@ -2,14 +2,22 @@ package main
 
 import (
 	"errors"
+	"sync"
+	"time"
 
 	"github.com/cymony/cryptomony/opaque"
 )
 
+var errClientNotInitialized = newError(codeNotInitialized, "client must be initialized first")
+
 type client struct {
+	mu            sync.RWMutex
 	isInitialized bool
 	cConf         *opaque.ClientConfiguration
 	c             opaque.Client
+	seq           sequencer
+	sessions      sessionStore
+	sealer        stateSealer
 }
 
 func newClient() *client {
@@ -20,11 +28,24 @@ func newClient() *client {
 // Takes one argument and it is string, returns []byte for registration request
 // Prototype Go: RegistrationInit(password string) []byte
 // Prototype JS: registrationInit(password: string) Uint8Array
-func (c *client) RegistrationInit(password string) ([]byte, []byte, error) {
-	if !c.IsInitialized() {
-		return nil, nil, errors.New("client must be initialized first")
+func (c *client) RegistrationInit(password string) (_ []byte, _ []byte, err error) {
+	if err := c.seq.enter("registrationInit", phaseIdle); err != nil {
+		return nil, nil, err
 	}
+	defer c.seq.leave(phaseRegistration, &err)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.isInitialized {
+		return nil, nil, errClientNotInitialized
+	}
+
+	return c.registrationInit(password)
+}
 
+// registrationInit must be called with c.mu held.
+func (c *client) registrationInit(password string) ([]byte, []byte, error) {
 	regState, regReq, err := c.c.CreateRegistrationRequest([]byte(password))
 	if err != nil {
 		return nil, nil, err
@@ -35,6 +56,11 @@ func (c *client) RegistrationInit(password string) ([]byte, []byte, error) {
 		return nil, nil, err
 	}
 
+	encodedRegState, err = c.sealer.seal(stateClientRegistration, c.cConf.OpaqueSuite, c.cConf.ServerID, encodedRegState, time.Now().Add(sessionLifetime))
+	if err != nil {
+		return nil, nil, err
+	}
+
 	encodedRegReq, err := regReq.Encode()
 	if err != nil {
 		return nil, nil, err
@@ -48,18 +74,40 @@ func (c *client) RegistrationInit(password string) ([]byte, []byte, error) {
 // returns []byte for registration record and []byte for exportKey
 // Prototype Go: RegistrationFinalize(clientIdentity string, registrationRes []byte) ([]byte, []byte)
 // Prototype JS: registrationFinalize(clientIdentity: string, registrationRes: Uint8Array) Object(Uint8Array, Uint8Array)
-func (c *client) RegistrationFinalize(regState, regRes []byte, clientIdentity string) ([]byte, []byte, error) {
-	if !c.IsInitialized() {
-		return nil, nil, errors.New("client must be initialized first")
+func (c *client) RegistrationFinalize(regState, regRes []byte, clientIdentity string) (_ []byte, _ []byte, err error) {
+	if err := c.seq.enter("registrationFinalize", phaseRegistration); err != nil {
+		return nil, nil, err
 	}
+	defer c.seq.leave(phaseIdle, &err)
 
-	regisState := &opaque.ClientRegistrationState{}
-	if err := regisState.Decode(c.cConf.OpaqueSuite.New(), regState); err != nil {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.isInitialized {
+		return nil, nil, errClientNotInitialized
+	}
+
+	return c.registrationFinalize(regState, regRes, clientIdentity)
+}
+
+// registrationFinalize must be called with c.mu held.
+func (c *client) registrationFinalize(regState, regRes []byte, clientIdentity string) ([]byte, []byte, error) {
+	regState, err := c.sealer.open(stateClientRegistration, c.cConf.OpaqueSuite, c.cConf.ServerID, regState, "registrationState", time.Now())
+	if err != nil {
 		return nil, nil, err
 	}
+	defer wipeBytes(regState)
+
+	regisState := &opaque.ClientRegistrationState{}
+	if err := regisState.Decode(newSuite(c.cConf.OpaqueSuite), regState); err != nil {
+		return nil, nil, decodeError("registrationState", err)
+	}
 
 	regRecord, exportKey, err := c.c.FinalizeRegistrationRequest(regisState, []byte(clientIdentity), regRes)
 	if err != nil {
+		if errors.Is(err, opaque.ErrDecodingFailed) || errors.Is(err, opaque.ErrDeserializationFailed) {
+			return nil, nil, decodeError("registrationResponse", err)
+		}
 		return nil, nil, err
 	}
 
@@ -75,11 +123,24 @@ func (c *client) RegistrationFinalize(regState, regRes []byte, clientIdentity st
 // Takes one argument and it is string, returns []byte for ke1 message
 // Prototype Go: LoginInit(password string) []byte
 // Prototype JS: loginInit(password: string) Uint8Array
-func (c *client) LoginInit(password string) ([]byte, []byte, error) {
-	if !c.IsInitialized() {
-		return nil, nil, errors.New("client must be initialized first")
+func (c *client) LoginInit(password string) (_ []byte, _ []byte, err error) {
+	if err := c.seq.enter("loginInit", phaseIdle); err != nil {
+		return nil, nil, err
+	}
+	defer c.seq.leave(phaseLogin, &err)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.isInitialized {
+		return nil, nil, errClientNotInitialized
 	}
 
+	return c.loginInit(password)
+}
+
+// loginInit must be called with c.mu held.
+func (c *client) loginInit(password string) ([]byte, []byte, error) {
 	loginState, ke1Message, err := c.c.ClientInit([]byte(password))
 	if err != nil {
 		return nil, nil, err
@@ -90,6 +151,11 @@ func (c *client) LoginInit(password string) ([]byte, []byte, error) {
 		return nil, nil, err
 	}
 
+	encodedLoginState, err = c.sealer.seal(stateClientLogin, c.cConf.OpaqueSuite, c.cConf.ServerID, encodedLoginState, time.Now().Add(sessionLifetime))
+	if err != nil {
+		return nil, nil, err
+	}
+
 	encodedKE1Message, err := ke1Message.Encode()
 	if err != nil {
 		return nil, nil, err
@@ -103,19 +169,44 @@ func (c *client) LoginInit(password string) ([]byte, []byte, error) {
 // returns []byte for ke3 message, []byte for sessionKey and []byte for exportKey
 // Prototype Go: LoginFinish(clientIdentity string, ke2Message []byte) ([]byte, []byte, []byte)
 // Prototype JS: loginFinish(clientIdentity: string, ke2Message Uint8Array) Object(Uint8Array, Uint8Array, Uint8Array)
-func (c *client) LoginFinish(loginState, ke2 []byte, clientIdentity string) ([]byte, []byte, []byte, error) {
-	if !c.IsInitialized() {
-		return nil, nil, nil, errors.New("client must be initialized first")
+func (c *client) LoginFinish(loginState, ke2 []byte, clientIdentity string) (_ []byte, _ []byte, _ []byte, err error) {
+	if err := c.seq.enter("loginFinish", phaseLogin); err != nil {
+		return nil, nil, nil, err
+	}
+	defer c.seq.leave(phaseIdle, &err)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.isInitialized {
+		return nil, nil, nil, errClientNotInitialized
 	}
 
+	return c.loginFinish(loginState, ke2, clientIdentity)
+}
+
+// loginFinish must be called with c.mu held.
+func (c *client) loginFinish(loginState, ke2 []byte, clientIdentity string) ([]byte, []byte, []byte, error) {
+	loginState, err := c.sealer.open(stateClientLogin, c.cConf.OpaqueSuite, c.cConf.ServerID, loginState, "loginState", time.Now())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer wipeBytes(loginState)
+
 	logState := &opaque.ClientLoginState{}
-	if err := logState.Decode(c.cConf.OpaqueSuite.New(), loginState); err != nil {
+	if err := logState.Decode(newSuite(c.cConf.OpaqueSuite), loginState); err != nil {
+		return nil, nil, nil, decodeError("loginState", err)
+	}
+
+	if _, err := decodeKE2(newSuite(c.cConf.OpaqueSuite), ke2); err != nil {
 		return nil, nil, nil, err
 	}
 
 	ke3Message, sessionKey, exportKey, err := c.c.ClientFinish(logState, []byte(clientIdentity), ke2)
 	if err != nil {
-		return nil, nil, nil, err
+		// A wrong password or server garbles the recovered credentials,
+		// so every failure past decoding is an authentication failure.
+		return nil, nil, nil, &apiError{code: codeAuthFailed, msg: "authentication failed", err: err}
 	}
 
 	encodedKE3Message, err := ke3Message.Encode()
@@ -126,10 +217,155 @@ func (c *client) LoginFinish(loginState, ke2 []byte, clientIdentity string) ([]b
 	return encodedKE3Message, sessionKey, exportKey, nil
 }
 
+// LoginInitSession is like LoginInit but keeps the login state inside the client
+// and returns a session handle for it instead.
+func (c *client) LoginInitSession(password string) (string, []byte, error) {
+	loginState, ke1, err := c.LoginInit(password)
+	if err != nil {
+		return "", nil, err
+	}
+
+	handle, err := c.sessions.put(loginState, time.Now(), time.Now().Add(sessionLifetime))
+	if err != nil {
+		wipeBytes(loginState)
+		return "", nil, err
+	}
+
+	return handle, ke1, nil
+}
+
+// LoginFinishSession is like LoginFinish but takes the login state of the session handle.
+// The state is wiped whether or not the login succeeds.
+func (c *client) LoginFinishSession(handle string, ke2 []byte, clientIdentity string) ([]byte, []byte, []byte, error) {
+	loginState, err := c.sessions.take(handle, time.Now())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer wipeBytes(loginState)
+
+	return c.LoginFinish(loginState, ke2, clientIdentity)
+}
+
+// ChangePasswordInit starts a password change: a login with the old password and a registration
+// of the new one. The pending states are kept inside the client and a session handle is returned
+// with the KE1 and the registration request to send to the server.
+func (c *client) ChangePasswordInit(oldPassword, newPassword string) (_ string, _ []byte, _ []byte, err error) {
+	if err := c.seq.enter("changePasswordInit", phaseIdle); err != nil {
+		return "", nil, nil, err
+	}
+	defer c.seq.leave(phaseLogin, &err)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.isInitialized {
+		return "", nil, nil, errClientNotInitialized
+	}
+
+	loginState, ke1, err := c.loginInit(oldPassword)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	defer wipeBytes(loginState)
+
+	regState, regReq, err := c.registrationInit(newPassword)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	defer wipeBytes(regState)
+
+	state, err := encodeChangeState(loginState, regState)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	handle, err := c.sessions.put(state, time.Now(), time.Now().Add(sessionLifetime))
+	if err != nil {
+		wipeBytes(state)
+		return "", nil, nil, err
+	}
+
+	return handle, ke1, regReq, nil
+}
+
+// ChangePasswordFinish finishes the password change of the session handle. The login with the old password
+// must succeed before the new password is registered. The vault blobs sealed with the old export key are
+// sealed again with the new one. The pending states are wiped whether or not the change succeeds.
+func (c *client) ChangePasswordFinish(handle string, ke2, regRes []byte, clientIdentity string, vaultBlobs [][]byte) (_ *passwordChange, err error) {
+	if err := c.seq.enter("changePasswordFinish", phaseLogin); err != nil {
+		return nil, err
+	}
+	defer c.seq.leave(phaseIdle, &err)
+
+	state, err := c.sessions.take(handle, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer wipeBytes(state)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.isInitialized {
+		return nil, errClientNotInitialized
+	}
+
+	loginState, regState, err := decodeChangeState(state)
+	if err != nil {
+		return nil, err
+	}
+	defer wipeBytes(loginState)
+	defer wipeBytes(regState)
+
+	ke3, sessionKey, oldExportKey, err := c.loginFinish(loginState, ke2, clientIdentity)
+	if err != nil {
+		return nil, err
+	}
+
+	record, newExportKey, err := c.registrationFinalize(regState, regRes, clientIdentity)
+	if err != nil {
+		return nil, err
+	}
+
+	vaults, err := resealVaults(c.cConf.OpaqueSuite, oldExportKey, newExportKey, clientIdentity, vaultBlobs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &passwordChange{
+		ke3:          ke3,
+		record:       record,
+		sessionKey:   sessionKey,
+		oldExportKey: oldExportKey,
+		newExportKey: newExportKey,
+		vaultBlobs:   vaults,
+	}, nil
+}
+
 func (c *client) IsInitialized() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	return c.isInitialized
 }
 
+// destroy wipes the client configuration and leaves the client uninitialized.
+func (c *client) destroy() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cConf != nil {
+		wipeBytes(c.cConf.ServerID)
+	}
+
+	c.isInitialized = false
+	c.cConf = nil
+	c.c = nil
+	c.seq.setStrict(false)
+	c.sessions.clear()
+	c.sealer.disable()
+}
+
 // InitializeClient wasm wrapper for opaque.NewClient
 // Takes two argument, both are string, returns nothing. But resolve promise if successful.
 // Prototype Go: InitializeClient(suiteName string, serverID string)
@@ -144,7 +380,10 @@ func (c *client) InitializeClient(suiteName string, serverID string) error {
 	cConf.OpaqueSuite = suiteID
 	cConf.ServerID = []byte(serverID)
 
-	c.c = opaque.NewClient(cConf)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.c = newOpaqueClient(cConf)
 	c.isInitialized = true
 	c.cConf = cConf
 
@@ -1,24 +1,18 @@
+//go:build js && wasm
+
 package main
 
 import (
-	"errors"
-	"math/rand"
 	"syscall/js"
-	"time"
-	"unsafe"
 )
 
 type clientManager struct {
-	clients map[string]*client
-	rndSrc  rand.Source
+	clients *registry[*client]
 }
 
 func newClientManager() *clientManager {
-	src := rand.NewSource(time.Now().UnixNano())
-
 	return &clientManager{
-		clients: make(map[string]*client),
-		rndSrc:  src,
+		clients: newRegistry("client", newClient),
 	}
 }
 
@@ -26,20 +20,25 @@ func (cm *clientManager) exposeToJS(rootModule js.Value) {
 	rootModule.Set("client", make(map[string]interface{}))
 	clientModule := rootModule.Get("client")
 
-	clientModule.Set("newClient", js.FuncOf(cm.NewClient))
+	clientModule.Set("newClient", js.FuncOf(cm.clients.JSCreate))
 	clientModule.Set("initClient", js.FuncOf(cm.InitClient))
 	clientModule.Set("isInitialized", js.FuncOf(cm.IsInitialized))
 	clientModule.Set("registrationInit", js.FuncOf(cm.RegistrationInit))
 	clientModule.Set("registrationFinalize", js.FuncOf(cm.RegistrationFinalize))
 	clientModule.Set("loginInit", js.FuncOf(cm.LoginInit))
 	clientModule.Set("loginFinish", js.FuncOf(cm.LoginFinish))
-}
-
-// NewClient creates new empty client instance with identifier. It returns the identifier.
-func (cm *clientManager) NewClient(this js.Value, inputs []js.Value) any {
-	clid := cm.GenerateRandomID()
-	cm.clients[clid] = newClient()
-	return clid
+	clientModule.Set("loginInitSession", js.FuncOf(cm.LoginInitSession))
+	clientModule.Set("loginFinishSession", js.FuncOf(cm.LoginFinishSession))
+	clientModule.Set("changePasswordInit", js.FuncOf(cm.ChangePasswordInit))
+	clientModule.Set("changePasswordFinish", js.FuncOf(cm.ChangePasswordFinish))
+	clientModule.Set("enableStateSealing", js.FuncOf(cm.EnableStateSealing))
+	clientModule.Set("disableStateSealing", js.FuncOf(cm.DisableStateSealing))
+	clientModule.Set("setStrictMode", js.FuncOf(cm.SetStrictMode))
+	clientModule.Set("getPhase", js.FuncOf(cm.GetPhase))
+	clientModule.Set("destroyClient", js.FuncOf(cm.clients.JSDestroy))
+	clientModule.Set("destroyAll", js.FuncOf(cm.clients.JSDestroyAll))
+	clientModule.Set("listClients", js.FuncOf(cm.clients.JSList))
+	clientModule.Set("setMaxClients", js.FuncOf(cm.clients.JSSetMaxInstances))
 }
 
 // InitClient initializes the already existing client instance with configuration.
@@ -236,47 +235,276 @@ func (cm *clientManager) LoginFinish(this js.Value, inputs []js.Value) any {
 	return promiser(runner)
 }
 
-func (cm *clientManager) getClient(inputs []js.Value, inputLen int) (*client, error) {
-	if err := checkInputLen(inputs, inputLen); err != nil {
-		return nil, err
+// LoginInitSession starts a login keeping the login state inside the client.
+// It resolves with a session handle instead of the login state.
+func (cm *clientManager) LoginInitSession(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		cl, err := cm.getClient(inputs, 2)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenPassword := inputs[1]
+		if err := checkIsString(chosenPassword, "password"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		session, ke1, err := cl.LoginInitSession(chosenPassword.String())
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		returnObj := make(map[string]interface{})
+		returnObj["session"] = session
+		returnObj["ke1"] = copyBytesToJS(ke1)
+
+		resolve.Invoke(returnObj)
 	}
+	return promiser(runner)
+}
+
+// LoginFinishSession finishes the login started by LoginInitSession.
+// The login state of the session is wiped whether or not the login succeeds.
+func (cm *clientManager) LoginFinishSession(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		cl, err := cm.getClient(inputs, 4)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
 
-	clIdentifier := inputs[0]
+		chosenSession := inputs[1]
+		chosenKE2 := inputs[2]
+		chosenClientIdentity := inputs[3]
+
+		if err := checkIsString(chosenSession, "session"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		ke2Message, err := copyBytesToGo(chosenKE2, "ke2Message")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
 
-	if err := checkIsString(clIdentifier, "clientID"); err != nil {
-		return nil, err
+		if err := checkIsString(chosenClientIdentity, "clientIdentity"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		ke3Message, sessionKey, exportKey, err := cl.LoginFinishSession(chosenSession.String(), ke2Message, chosenClientIdentity.String())
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		returnObj := make(map[string]interface{})
+		returnObj["ke3"] = copyBytesToJS(ke3Message)
+		returnObj["sessionKey"] = copyBytesToJS(sessionKey)
+		returnObj["exportKey"] = copyBytesToJS(exportKey)
+
+		resolve.Invoke(returnObj)
 	}
+	return promiser(runner)
+}
 
-	cl, ok := cm.clients[clIdentifier.String()]
-	if !ok {
-		return nil, errors.New("client not found")
+// ChangePasswordInit starts a password change with the old and the new password.
+// It resolves with a session handle, the KE1 and the registration request for the server.
+func (cm *clientManager) ChangePasswordInit(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		cl, err := cm.getClient(inputs, 3)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenOldPassword := inputs[1]
+		chosenNewPassword := inputs[2]
+
+		if err := checkIsString(chosenOldPassword, "oldPassword"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsString(chosenNewPassword, "newPassword"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		session, ke1, regReq, err := cl.ChangePasswordInit(chosenOldPassword.String(), chosenNewPassword.String())
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		returnObj := make(map[string]interface{})
+		returnObj["session"] = session
+		returnObj["ke1"] = copyBytesToJS(ke1)
+		returnObj["registrationRequest"] = copyBytesToJS(regReq)
+
+		resolve.Invoke(returnObj)
 	}
+	return promiser(runner)
+}
 
-	return cl, nil
+// ChangePasswordFinish finishes the password change started by ChangePasswordInit and seals
+// the optional vault blobs again with the new export key.
+func (cm *clientManager) ChangePasswordFinish(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		cl, err := cm.clients.lookupBetween(inputs, 5, 6, "clientID")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenSession := inputs[1]
+		chosenClientIdentity := inputs[4]
+
+		if err := checkIsString(chosenSession, "session"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		ke2Message, err := copyBytesToGo(inputs[2], "ke2Message")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		regRes, err := copyBytesToGo(inputs[3], "registrationResponse")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsString(chosenClientIdentity, "clientIdentity"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		var vaultBlobs [][]byte
+		if len(inputs) == 6 && !isNullish(inputs[5]) {
+			if err := checkArrType(inputs[5], "Array", "vaultBlobs"); err != nil {
+				rejectErr(reject, err)
+				return
+			}
+
+			for i := 0; i < inputs[5].Length(); i++ {
+				blob, err := copyBytesToGo(inputs[5].Index(i), "vaultBlobs")
+				if err != nil {
+					rejectErr(reject, err)
+					return
+				}
+				vaultBlobs = append(vaultBlobs, blob)
+			}
+		}
+
+		change, err := cl.ChangePasswordFinish(chosenSession.String(), ke2Message, regRes, chosenClientIdentity.String(), vaultBlobs)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		vaultBlobsJS := make([]interface{}, len(change.vaultBlobs))
+		for i, blob := range change.vaultBlobs {
+			vaultBlobsJS[i] = copyBytesToJS(blob)
+		}
+
+		returnObj := make(map[string]interface{})
+		returnObj["ke3"] = copyBytesToJS(change.ke3)
+		returnObj["registrationRecord"] = copyBytesToJS(change.record)
+		returnObj["sessionKey"] = copyBytesToJS(change.sessionKey)
+		returnObj["oldExportKey"] = copyBytesToJS(change.oldExportKey)
+		returnObj["newExportKey"] = copyBytesToJS(change.newExportKey)
+		returnObj["vaultBlobs"] = vaultBlobsJS
+
+		resolve.Invoke(returnObj)
+	}
+	return promiser(runner)
+}
+
+// EnableStateSealing seals the registration and login states returned by the client
+// with an AEAD key held by the client. A new key is generated when key is null.
+// It resolves with the key in use.
+func (cm *clientManager) EnableStateSealing(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		cl, err := cm.getClient(inputs, 2)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		key, err := copyOptionalBytesToGo(inputs[1], "key")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		key, err = cl.sealer.setKey(key)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke(copyBytesToJS(key))
+	}
+	return promiser(runner)
 }
 
-// GenerateRandomID generates random and unique client identifier
-func (cm *clientManager) GenerateRandomID() string {
-	n := 8
+// DisableStateSealing wipes the sealing key. States are returned unsealed afterwards.
+func (cm *clientManager) DisableStateSealing(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		cl, err := cm.getClient(inputs, 1)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		cl.sealer.disable()
+		resolve.Invoke()
+	}
+	return promiser(runner)
+}
 
-	b := make([]byte, n)
-	// A src.Int63() generates 63 random bits, enough for letterIdxMax characters!
-	for i, cache, remain := n-1, cm.rndSrc.Int63(), letterIdxMax; i >= 0; {
-		if remain == 0 {
-			cache, remain = cm.rndSrc.Int63(), letterIdxMax
+// SetStrictMode turns strict mode on or off. In strict mode protocol steps called out
+// of sequence are rejected with ERR_OUT_OF_SEQUENCE. Changing the mode resets the client to idle.
+func (cm *clientManager) SetStrictMode(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		cl, err := cm.getClient(inputs, 2)
+		if err != nil {
+			rejectErr(reject, err)
+			return
 		}
-		if idx := int(cache & letterIdxMask); idx < len(letterBytes) {
-			b[i] = letterBytes[idx]
-			i--
+
+		if err := checkIsBool(inputs[1], "enabled"); err != nil {
+			rejectErr(reject, err)
+			return
 		}
-		cache >>= letterIdxBits
-		remain--
+
+		cl.seq.setStrict(inputs[1].Bool())
+		resolve.Invoke()
 	}
+	return promiser(runner)
+}
 
-	generatedString := *(*string)(unsafe.Pointer(&b))
-	_, ok := cm.clients[generatedString]
-	if ok {
-		return cm.GenerateRandomID()
+// GetPhase returns the protocol phase of the client: idle, registration, login or busy.
+func (cm *clientManager) GetPhase(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		cl, err := cm.getClient(inputs, 1)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke(string(cl.seq.current()))
 	}
-	return generatedString
+	return promiser(runner)
+}
+
+func (cm *clientManager) getClient(inputs []js.Value, inputLen int) (*client, error) {
+	return cm.clients.lookup(inputs, inputLen, "clientID")
 }
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// The managers only add argument conversion on top of the registries and instances exercised here,
+// so running this under the race detector on the host covers their synchronization:
+//
+//	go test -race ./...
+
+// TestRegistriesConcurrent creates, looks up, lists and destroys client and server instances
+// from many goroutines at once.
+func TestRegistriesConcurrent(t *testing.T) {
+	clients := newRegistry("client", newClient)
+	servers := newRegistry("server", newServer)
+
+	var wg sync.WaitGroup
+
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 50; i++ {
+				clID, err := clients.create()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				svID, err := servers.create()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				if _, err := clients.get(clID); err != nil {
+					t.Error(err)
+				}
+
+				if _, err := servers.get(svID); err != nil {
+					t.Error(err)
+				}
+
+				clients.list()
+				servers.list()
+
+				if err := clients.destroy(clID); err != nil {
+					t.Error(err)
+				}
+
+				if err := servers.destroy(svID); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 50; i++ {
+			if err := servers.setMaxInstances(0); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	if n := len(clients.list()) + len(servers.list()); n != 0 {
+		t.Fatalf("%d instances left after destroying all", n)
+	}
+}
+
+// TestLoginsConcurrent runs registrations and logins of many clients against one server at once,
+// with sessions and sealed states, while another goroutine destroys and recreates unrelated servers.
+func TestLoginsConcurrent(t *testing.T) {
+	servers := newRegistry("server", newServer)
+
+	svID, err := servers.create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sv, err := servers.get(svID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sv.InitializeServer("Ristretto255Suite", "example.com", nil, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sv.sealer.setKey(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	churnDone := make(chan struct{})
+
+	go func() {
+		defer close(churnDone)
+
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			id, err := servers.create()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if err := servers.destroy(id); err != nil {
+				t.Error(err)
+				return
+			}
+
+			// Without preemption, as on js/wasm, the logins would never get to run.
+			runtime.Gosched()
+		}
+	}()
+
+	var wg sync.WaitGroup
+
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+
+		go func(g int) {
+			defer wg.Done()
+
+			if err := concurrentLogin(sv, fmt.Sprintf("user-%d", g)); err != nil {
+				t.Error(err)
+			}
+		}(g)
+	}
+
+	wg.Wait()
+	close(stop)
+	<-churnDone
+
+	if got := sv.stats.loginsSucceeded.Load(); got != 16 {
+		t.Fatalf("got %d successful logins, want 16", got)
+	}
+}
+
+// concurrentLogin registers credID on sv and logs in twice, once with the login state
+// returned to the caller and once with a session handle.
+func concurrentLogin(sv *server, credID string) error {
+	cl := newClient()
+	defer cl.destroy()
+
+	if err := cl.InitializeClient("Ristretto255Suite", "example.com"); err != nil {
+		return err
+	}
+
+	password := "password of " + credID
+
+	regState, regReq, err := cl.RegistrationInit(password)
+	if err != nil {
+		return err
+	}
+
+	regRes, err := sv.RegistrationEval(regReq, nil, credID)
+	if err != nil {
+		return err
+	}
+
+	record, _, err := cl.RegistrationFinalize(regState, regRes, credID)
+	if err != nil {
+		return err
+	}
+
+	if err := sv.RegisterUpload(credID, record); err != nil {
+		return err
+	}
+
+	for _, withSession := range []bool{false, true} {
+		loginState, ke1, err := cl.LoginInit(password)
+		if err != nil {
+			return err
+		}
+
+		var (
+			svState []byte
+			session string
+			ke2     []byte
+		)
+
+		if withSession {
+			session, ke2, err = sv.LoginInitSession(record, ke1, nil, "", credID, credID)
+		} else {
+			svState, ke2, err = sv.LoginInit(record, ke1, nil, credID, credID)
+		}
+		if err != nil {
+			return err
+		}
+
+		ke3, clientSessionKey, _, err := cl.LoginFinish(loginState, ke2, credID)
+		if err != nil {
+			return err
+		}
+
+		var serverSessionKey []byte
+
+		if withSession {
+			serverSessionKey, err = sv.LoginFinishSession(session, ke3)
+		} else {
+			serverSessionKey, err = sv.LoginFinish(svState, ke3)
+		}
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(clientSessionKey, serverSessionKey) {
+			return fmt.Errorf("%s: session keys differ", credID)
+		}
+	}
+
+	return nil
+}
+
+// TestOprfSeedRemovalConcurrent removes the oprf seed of a record while keyed logins with it
+// are running. Every login must either complete or find the seed gone, never use a wiped seed.
+func TestOprfSeedRemovalConcurrent(t *testing.T) {
+	sv := newServer()
+	if err := sv.InitializeServer("Ristretto255Suite", "example.com", nil, nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sv.AddOprfSeed("old", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cl := newClient()
+	if err := cl.InitializeClient("Ristretto255Suite", "example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	regState, regReq, err := cl.RegistrationInit("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	regRes, keyID, _, err := sv.RegistrationEvalKeyed(regReq, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, _, err := cl.RegistrationFinalize(regState, regRes, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 4; i++ {
+				loginState, ke1, err := cl.LoginInit("password")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				svState, ke2, err := sv.LoginInitKeyed(record, ke1, keyID, "", "alice", "alice")
+				if errors.Is(err, errOprfSeedNotFound) {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				ke3, _, _, err := cl.LoginFinish(loginState, ke2, "alice")
+				if err != nil {
+					t.Errorf("login started before the seed removal: %v", err)
+					return
+				}
+
+				if _, err := sv.LoginFinish(svState, ke3); err != nil {
+					t.Error(err)
+					return
+				}
+
+				runtime.Gosched()
+			}
+		}()
+	}
+
+	if _, err := sv.AddOprfSeed("new", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sv.SetActiveOprfSeed("new"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sv.RemoveOprfSeed(keyID); err != nil {
+		t.Fatal(err)
+	}
+
+	wg.Wait()
+}
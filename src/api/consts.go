@@ -1,15 +1,30 @@
 package main
 
+import "github.com/cymony/cryptomony/opaque"
+
 type suite string
 
 var (
 	ristretto255Suite suite = "Ristretto255Suite"
 	p256Suite         suite = "P256Suite"
+	p384Suite         suite = "P384Suite"
+	p521Suite         suite = "P521Suite"
 )
 
-const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+// supportedSuites maps the suite names to their identifiers, in the order reported by getSupportedSuites.
+// Only suites that newSuite can construct may be listed.
+var supportedSuites = []struct {
+	name suite
+	id   opaque.Identifier
+}{
+	{name: ristretto255Suite, id: opaque.Ristretto255Suite},
+	{name: p256Suite, id: opaque.P256Suite},
+	{name: p384Suite, id: p384SuiteID},
+	{name: p521Suite, id: p521SuiteID},
+}
+
 const (
-	letterIdxBits = 6                    // 6 bits to represent a letter index
-	letterIdxMask = 1<<letterIdxBits - 1 // All 1-bits, as many as letterIdxBits
-	letterIdxMax  = 63 / letterIdxBits   // # of letter indices fitting in 63 bits
+	idByteLen     = 16   // 128-bit instance identifiers
+	maxIDAttempts = 8    // # of tries before giving up on a unique identifier
+	maxTombstones = 4096 // # of destroyed identifiers remembered per registry
 )
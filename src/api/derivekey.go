@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/cymony/cryptomony/opaque"
+	"github.com/cymony/cryptomony/utils"
+)
+
+var (
+	labelDeriveKey = "cryptomonyjs-opaque DeriveKey"
+	labelPrefix    = "cryptomonyjs-opaque" // reserved for the labels of the module
+)
+
+// deriveKey derives a subkey of length bytes for the application label from an export key
+// with HKDF over the hash of the suite:
+//
+//	prk = Extract("cryptomonyjs-opaque DeriveKey", exportKey)
+//	key = Expand(prk, concat(I2OSP(len(label), 2), label), length)
+//
+// The length prefix keeps every label in its own domain. The keys used inside the module, such as
+// the vault key, are extracted with other salts, and labels starting with the module prefix are
+// rejected, so no label reaches them.
+func deriveKey(suite opaque.Suite, exportKey []byte, label string, length int) ([]byte, error) {
+	if len(exportKey) != suite.Nh() {
+		return nil, argError("exportKey", "export key must be %d bytes", suite.Nh())
+	}
+
+	if len(label) == 0 || len(label) > 0xffff {
+		return nil, argError("label", "label must be between 1 and %d bytes", 0xffff)
+	}
+
+	if strings.HasPrefix(label, labelPrefix) {
+		return nil, argError("label", "labels starting with %q are reserved", labelPrefix)
+	}
+
+	if length < 1 || length > 255*suite.Nh() {
+		return nil, argError("length", "length must be between 1 and %d", 255*suite.Nh())
+	}
+
+	labelLen := make([]byte, 2)
+	binary.BigEndian.PutUint16(labelLen, uint16(len(label)))
+
+	prk := suite.Extract([]byte(labelDeriveKey), exportKey)
+	defer wipeBytes(prk)
+
+	return suite.Expand(prk, utils.Concat(labelLen, []byte(label)), length), nil
+}
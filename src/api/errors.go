@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cymony/cryptomony/opaque"
+)
+
+// errorCode is the stable code attached to errors crossing the JS boundary.
+type errorCode string
+
+const (
+	codeNotInitialized    errorCode = "ERR_NOT_INITIALIZED"
+	codeInvalidArgument   errorCode = "ERR_INVALID_ARGUMENT"
+	codeAuthFailed        errorCode = "ERR_AUTH_FAILED"
+	codeDecode            errorCode = "ERR_DECODE"
+	codeNotFound          errorCode = "ERR_NOT_FOUND"
+	codeInstanceDestroyed errorCode = "ERR_INSTANCE_DESTROYED"
+	codeLimitExceeded     errorCode = "ERR_LIMIT_EXCEEDED"
+	codeOutOfSequence     errorCode = "ERR_OUT_OF_SEQUENCE"
+	codeExpired           errorCode = "ERR_EXPIRED"
+	codeTimeout           errorCode = "ERR_TIMEOUT"
+	codeLocked            errorCode = "ERR_LOCKED"
+	codeInternal          errorCode = "ERR_INTERNAL"
+)
+
+// apiError is an error with a stable code and, where relevant, the name of the offending argument.
+type apiError struct {
+	code    errorCode
+	argName string
+	msg     string
+	err     error // wrapped cause, optional
+}
+
+func (e *apiError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("%s: %s", e.msg, e.err.Error())
+	}
+	return e.msg
+}
+
+func (e *apiError) Unwrap() error {
+	return e.err
+}
+
+func newError(code errorCode, msg string) *apiError {
+	return &apiError{code: code, msg: msg}
+}
+
+// argError creates an ERR_INVALID_ARGUMENT error for the named argument.
+func argError(argName, format string, a ...any) *apiError {
+	return &apiError{code: codeInvalidArgument, argName: argName, msg: fmt.Sprintf(format, a...)}
+}
+
+// decodeError wraps err into an ERR_DECODE error for the named argument.
+func decodeError(argName string, err error) *apiError {
+	return &apiError{code: codeDecode, argName: argName, msg: fmt.Sprintf("%s could not be decoded", argName), err: err}
+}
+
+var (
+	errInstanceDestroyed = newError(codeInstanceDestroyed, "instance destroyed")
+	errIDGeneration      = newError(codeInternal, "could not generate unique identifier")
+	errMaxInstances      = newError(codeLimitExceeded, "maximum number of instances reached")
+)
+
+// classifyError returns the code and argument name of err.
+// Errors of the opaque package are mapped to the closest code.
+func classifyError(err error) (errorCode, string) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.code, apiErr.argName
+	}
+
+	switch {
+	case errors.Is(err, opaque.ErrClientAuthentication),
+		errors.Is(err, opaque.ErrServerAuthentication),
+		errors.Is(err, opaque.ErrEnvelopeRecovery),
+		errors.Is(err, opaque.ErrRecoverCredentialsFailed):
+		return codeAuthFailed, ""
+	case errors.Is(err, opaque.ErrDecodingFailed),
+		errors.Is(err, opaque.ErrDeserializationFailed):
+		return codeDecode, ""
+	case errors.Is(err, opaque.ErrOPRFSeedLength):
+		return codeInvalidArgument, "oprfSeed"
+	case errors.Is(err, opaque.ErrSeedLength):
+		return codeInvalidArgument, ""
+	default:
+		return codeInternal, ""
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/cymony/cryptomony/opaque"
+	"github.com/cymony/cryptomony/utils"
+)
+
+var (
+	labelFakeClientKey  = "cryptomonyjs-opaque FakeClientKey"
+	labelFakeMaskingKey = "cryptomonyjs-opaque FakeMaskingKey"
+)
+
+// fakeRecord derives a registration record for a credential identifier that has none.
+// The same oprf seed and credential identifier always result in the same record,
+// so repeated logins for an unknown user look like logins for a registered one.
+// Reference: https://www.ietf.org/archive/id/draft-irtf-cfrg-opaque-09.html#name-preventing-client-enumerati
+func fakeRecord(suite opaque.Suite, oprfSeed, credID []byte) (*opaque.RegistrationRecord, error) {
+	if len(oprfSeed) != suite.Nh() {
+		return nil, opaque.ErrOPRFSeedLength
+	}
+
+	// seed = Expand(oprf_seed, concat(credential_identifier, "FakeClientKey"), Nseed)
+	seed := suite.Expand(oprfSeed, utils.Concat(credID, []byte(labelFakeClientKey)), suite.Nseed())
+
+	// (_, client_public_key) = DeriveAuthKeyPair(seed)
+	cPrivKey, err := suite.DeriveAuthKeyPair(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	// masking_key = Expand(oprf_seed, concat(credential_identifier, "FakeMaskingKey"), Nh)
+	maskingKey := suite.Expand(oprfSeed, utils.Concat(credID, []byte(labelFakeMaskingKey)), suite.Nh())
+
+	// envelope = zeroes(Ne)
+	envelope := &opaque.Envelope{
+		Nonce:   make([]byte, suite.Nn()),
+		AuthTag: make([]byte, suite.Nm()),
+	}
+
+	return &opaque.RegistrationRecord{
+		Envelope:     envelope,
+		ClientPubKey: cPrivKey.Public(),
+		MaskingKey:   maskingKey,
+	}, nil
+}
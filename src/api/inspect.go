@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/cymony/cryptomony/opaque"
+)
+
+// fieldKind tells how a message field is checked.
+type fieldKind int
+
+const (
+	fieldBytes     fieldKind = iota // any value
+	fieldElement                    // OPRF group element other than the identity
+	fieldPublicKey                  // AKE public key
+)
+
+type fieldSpec struct {
+	name   string
+	kind   fieldKind
+	secret bool // the value is not reported
+	length func(s opaque.Suite) int
+}
+
+// messageSpecs lists the fields of every message type in wire order.
+// length is the length of the field value without its length prefix.
+var messageSpecs = map[string][]fieldSpec{
+	"registrationRequest": {
+		{name: "blindedMessage", kind: fieldElement, length: opaque.Suite.Noe},
+	},
+	"registrationResponse": {
+		{name: "evaluatedMessage", kind: fieldElement, length: opaque.Suite.Noe},
+		{name: "serverPublicKey", kind: fieldPublicKey, length: opaque.Suite.Npk},
+	},
+	"registrationRecord": {
+		{name: "clientPublicKey", kind: fieldPublicKey, length: opaque.Suite.Npk},
+		{name: "maskingKey", secret: true, length: opaque.Suite.Nh},
+		{name: "envelopeNonce", length: opaque.Suite.Nn},
+		{name: "envelopeAuthTag", length: opaque.Suite.Nm},
+	},
+	"ke1": {
+		{name: "blindedMessage", kind: fieldElement, length: opaque.Suite.Noe},
+		{name: "clientNonce", length: opaque.Suite.Nn},
+		{name: "clientKeyshare", kind: fieldPublicKey, length: opaque.Suite.Npk},
+	},
+	"ke2": {
+		{name: "evaluatedMessage", kind: fieldElement, length: opaque.Suite.Noe},
+		{name: "maskingNonce", length: opaque.Suite.Nn},
+		{name: "maskedResponse", length: func(s opaque.Suite) int { return s.Npk() + s.Ne() }},
+		{name: "serverNonce", length: opaque.Suite.Nn},
+		{name: "serverKeyshare", kind: fieldPublicKey, length: opaque.Suite.Npk},
+		{name: "serverMac", length: opaque.Suite.Nm},
+	},
+	"ke3": {
+		{name: "clientMac", length: opaque.Suite.Nm},
+	},
+}
+
+type inspectedField struct {
+	name   string
+	offset int // offset of the value, after the length prefix
+	length int
+	value  []byte // nil for secret fields
+}
+
+// inspection is the result of decoding a message field by field.
+type inspection struct {
+	messageType string
+	suiteName   string
+	length      int
+	fields      []inspectedField
+	errField    string // first field that failed to decode, empty when valid
+	errMsg      string
+}
+
+// inspectMessage decodes the message of the given type field by field and reports
+// the first field that fails to decode. Secret fields are reported without their value.
+func inspectMessage(suiteName, messageType string, data []byte) (*inspection, error) {
+	suiteID, err := strToSuite(suiteName)
+	if err != nil {
+		return nil, err
+	}
+
+	specs, ok := messageSpecs[messageType]
+	if !ok {
+		return nil, argError("messageType", "unknown message type %q", messageType)
+	}
+
+	suite := newSuite(suiteID)
+	result := &inspection{messageType: messageType, suiteName: suiteName, length: len(data)}
+
+	// Every field is prefixed with its length in 2 bytes.
+	offset := 0
+	for _, spec := range specs {
+		want := spec.length(suite)
+
+		if len(data)-offset < 2 {
+			result.errField = spec.name
+			result.errMsg = "truncated: missing length prefix"
+			return result, nil
+		}
+
+		fieldLen := int(binary.BigEndian.Uint16(data[offset:]))
+		offset += 2
+
+		if fieldLen != want {
+			result.errField = spec.name
+			result.errMsg = fmt.Sprintf("unexpected length %d, want %d", fieldLen, want)
+			return result, nil
+		}
+
+		if len(data)-offset < fieldLen {
+			result.errField = spec.name
+			result.errMsg = fmt.Sprintf("truncated: %d of %d bytes", len(data)-offset, fieldLen)
+			return result, nil
+		}
+
+		value := data[offset : offset+fieldLen]
+
+		if err := checkField(suite, spec.kind, value); err != nil {
+			result.errField = spec.name
+			result.errMsg = err.Error()
+			return result, nil
+		}
+
+		field := inspectedField{name: spec.name, offset: offset, length: fieldLen}
+		if !spec.secret {
+			field.value = value
+		}
+
+		result.fields = append(result.fields, field)
+		offset += fieldLen
+	}
+
+	if offset != len(data) {
+		result.errField = "trailing"
+		result.errMsg = fmt.Sprintf("%d unexpected trailing bytes", len(data)-offset)
+	}
+
+	return result, nil
+}
+
+func checkField(suite opaque.Suite, kind fieldKind, value []byte) error {
+	switch kind {
+	case fieldElement:
+		el := suite.OPRF().Group().NewElement()
+		if err := el.Decode(value); err != nil {
+			return fmt.Errorf("invalid group element: %w", err)
+		}
+
+		if el.IsIdentity() {
+			return fmt.Errorf("group element is the identity")
+		}
+	case fieldPublicKey:
+		if err := (&opaque.PublicKey{}).UnmarshalBinary(suite, value); err != nil {
+			return fmt.Errorf("invalid public key: %w", err)
+		}
+	}
+
+	return nil
+}
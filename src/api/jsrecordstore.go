@@ -0,0 +1,66 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"syscall/js"
+)
+
+// jsRecordStore is a recordStore backed by JS callbacks:
+//
+//	get(credentialID: string): Uint8Array | null | Promise<Uint8Array | null>
+//	put(credentialID: string, record: Uint8Array): void | Promise<void>
+//	delete(credentialID: string): void | Promise<void>
+type jsRecordStore struct {
+	callbacks js.Value
+}
+
+func newJSRecordStore(callbacks js.Value) (*jsRecordStore, error) {
+	if callbacks.Type() != js.TypeObject {
+		return nil, argError("store", "store argument must be object or null")
+	}
+
+	for _, method := range []string{"get", "put", "delete"} {
+		if callbacks.Get(method).Type() != js.TypeFunction {
+			return nil, argError("store", "store must have a %s function", method)
+		}
+	}
+
+	return &jsRecordStore{callbacks: callbacks}, nil
+}
+
+func (rs *jsRecordStore) Get(credID string) ([]byte, bool, error) {
+	res, err := callJS(rs.callbacks, "get", credID)
+	if err != nil {
+		return nil, false, recordStoreError("get", err)
+	}
+
+	if isNullish(res) {
+		return nil, false, nil
+	}
+
+	record, err := copyBytesToGo(res, "record")
+	if err != nil {
+		return nil, false, recordStoreError("get", err)
+	}
+
+	return record, true, nil
+}
+
+func (rs *jsRecordStore) Put(credID string, record []byte) error {
+	if _, err := callJS(rs.callbacks, "put", credID, copyBytesToJS(record)); err != nil {
+		return recordStoreError("put", err)
+	}
+	return nil
+}
+
+func (rs *jsRecordStore) Delete(credID string) error {
+	if _, err := callJS(rs.callbacks, "delete", credID); err != nil {
+		return recordStoreError("delete", err)
+	}
+	return nil
+}
+
+func recordStoreError(method string, err error) error {
+	return &apiError{code: codeInternal, msg: "record store " + method + " failed", err: err}
+}
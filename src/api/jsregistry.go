@@ -0,0 +1,127 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"syscall/js"
+)
+
+// lookup checks the input length and returns the instance whose identifier is the first input.
+func (r *registry[T]) lookup(inputs []js.Value, inputLen int, argName string) (T, error) {
+	return r.lookupBetween(inputs, inputLen, inputLen, argName)
+}
+
+// lookupBetween is like lookup but accepts trailing optional inputs.
+func (r *registry[T]) lookupBetween(inputs []js.Value, minLen, maxLen int, argName string) (T, error) {
+	var zero T
+
+	if err := checkInputLenBetween(inputs, minLen, maxLen); err != nil {
+		return zero, err
+	}
+
+	if err := checkIsString(inputs[0], argName); err != nil {
+		return zero, err
+	}
+
+	return r.get(inputs[0].String())
+}
+
+// JSCreate creates new empty instance. It returns the identifier,
+// or an Error object if the instance could not be created.
+// Prototype JS: create() string
+func (r *registry[T]) JSCreate(this js.Value, inputs []js.Value) any {
+	id, err := r.create()
+	if err != nil {
+		return jsError(err)
+	}
+
+	return id
+}
+
+// JSDestroy removes the instance and wipes its secrets.
+// Prototype JS: destroy(identifier: string) Promise<void>
+func (r *registry[T]) JSDestroy(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		if err := checkInputLen(inputs, 1); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsString(inputs[0], "identifier"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := r.destroy(inputs[0].String()); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke()
+	}
+
+	return promiser(runner)
+}
+
+// JSDestroyAll removes every instance and wipes their secrets.
+// Prototype JS: destroyAll() Promise<void>
+func (r *registry[T]) JSDestroyAll(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		if err := checkInputLen(inputs, 0); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		r.destroyAll()
+		resolve.Invoke()
+	}
+
+	return promiser(runner)
+}
+
+// JSList returns the identifiers of the live instances.
+// Prototype JS: list() Promise<string[]>
+func (r *registry[T]) JSList(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		if err := checkInputLen(inputs, 0); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		ids := r.list()
+
+		idsJS := make([]interface{}, len(ids))
+		for i, id := range ids {
+			idsJS[i] = id
+		}
+
+		resolve.Invoke(idsJS)
+	}
+
+	return promiser(runner)
+}
+
+// JSSetMaxInstances limits the number of live instances. Zero removes the limit.
+// Prototype JS: setMaxInstances(max: number) Promise<void>
+func (r *registry[T]) JSSetMaxInstances(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		if err := checkInputLen(inputs, 1); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsNumber(inputs[0], "max"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := r.setMaxInstances(inputs[0].Int()); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke()
+	}
+
+	return promiser(runner)
+}
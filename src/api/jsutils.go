@@ -0,0 +1,188 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall/js"
+)
+
+func rejectErr(reject js.Value, err error) {
+	reject.Invoke(jsError(err))
+}
+
+func promiser(runner func(resolve js.Value, reject js.Value)) js.Value {
+	handler := js.FuncOf(func(this js.Value, args []js.Value) any {
+		resolve := args[0]
+		reject := args[1]
+
+		go runner(resolve, reject)
+
+		return nil
+	})
+
+	promiseConstructor := js.Global().Get("Promise")
+	return promiseConstructor.New(handler)
+}
+
+func checkIsString(input js.Value, argName string) error {
+	if input.Type() != js.TypeString {
+		return argError(argName, "%s argument must be string", argName)
+	}
+	return nil
+}
+
+func checkIsNumber(input js.Value, argName string) error {
+	if input.Type() != js.TypeNumber {
+		return argError(argName, "%s argument must be number", argName)
+	}
+	return nil
+}
+
+func checkIsBool(input js.Value, argName string) error {
+	if input.Type() != js.TypeBoolean {
+		return argError(argName, "%s argument must be boolean", argName)
+	}
+	return nil
+}
+
+func checkInputLenBetween(inputs []js.Value, min, max int) error {
+	if min == max {
+		return checkInputLen(inputs, min)
+	}
+
+	if len(inputs) < min || len(inputs) > max {
+		return newError(codeInvalidArgument, fmt.Sprintf("inputs must be between %d and %d of length", min, max))
+	}
+	return nil
+}
+
+func checkInputLen(inputs []js.Value, want int) error {
+	if len(inputs) != want {
+		return newError(codeInvalidArgument, fmt.Sprintf("inputs must be %d of length", want))
+	}
+	return nil
+}
+
+func copyBytesToGo(arr js.Value, argName string) ([]byte, error) {
+	if err := checkArrType(arr, "Uint8Array", argName); err != nil {
+		return nil, err
+	}
+
+	arrLen := arr.Get("length").Int()
+	res := make([]byte, arrLen)
+	js.CopyBytesToGo(res, arr)
+	return res, nil
+}
+
+// copyOptionalBytesToGo returns nil when arr is null, undefined or NaN.
+func copyOptionalBytesToGo(arr js.Value, argName string) ([]byte, error) {
+	if isNullish(arr) {
+		return nil, nil
+	}
+	return copyBytesToGo(arr, argName)
+}
+
+func isNullish(input js.Value) bool {
+	return input.IsNull() || input.IsUndefined() || input.IsNaN()
+}
+
+func copyBytesToJS(data []byte) js.Value {
+	arrConstructor := js.Global().Get("Uint8Array")
+	dataJS := arrConstructor.New(len(data))
+	js.CopyBytesToJS(dataJS, data)
+	return dataJS
+}
+
+func checkArrType(arr js.Value, typeStr string, argName string) error {
+	typeDef := js.Global().Get("Object").Get("prototype").Get("toString").Call("call", arr)
+	if !strings.Contains(typeDef.String(), typeStr) {
+		return argError(argName, "%s argument must be %s", argName, typeStr)
+	}
+	return nil
+}
+
+// callJS calls the method of obj and waits for the result when it is a Promise.
+// An exception thrown or a rejection is returned as an error.
+func callJS(obj js.Value, method string, args ...any) (res js.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if jsErr, ok := r.(js.Error); ok {
+				err = jsErr
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	return awaitJS(obj.Call(method, args...))
+}
+
+// invokeJS calls fn without waiting for the result. An exception thrown is returned as an error.
+func invokeJS(fn js.Value, args ...any) (res js.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if jsErr, ok := r.(js.Error); ok {
+				err = jsErr
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	return fn.Invoke(args...), nil
+}
+
+// awaitJS waits for v to settle when it is a Promise, otherwise it returns v.
+// It must not be called on the JS event loop.
+func awaitJS(v js.Value) (js.Value, error) {
+	if v.Type() != js.TypeObject || v.Get("then").Type() != js.TypeFunction {
+		return v, nil
+	}
+
+	var (
+		result   js.Value
+		rejected bool
+	)
+
+	done := make(chan struct{})
+	settle := func(isRejected bool) js.Func {
+		return js.FuncOf(func(this js.Value, args []js.Value) any {
+			if len(args) > 0 {
+				result = args[0]
+			}
+			rejected = isRejected
+			close(done)
+			return nil
+		})
+	}
+
+	onResolve, onReject := settle(false), settle(true)
+	defer onResolve.Release()
+	defer onReject.Release()
+
+	v.Call("then", onResolve, onReject)
+	<-done
+
+	if rejected {
+		return js.Undefined(), js.Error{Value: result}
+	}
+
+	return result, nil
+}
+
+// jsError creates a JS Error object carrying the library prefix, the error code
+// as the code property and the argument name, if any, as the argument property.
+func jsError(err error) js.Value {
+	code, argName := classifyError(err)
+
+	jsErr := js.Global().Get("Error").New(fmt.Sprintf("cryptomonyjs-opaque: %s", err.Error()))
+	jsErr.Set("code", string(code))
+
+	if argName != "" {
+		jsErr.Set("argument", argName)
+	}
+
+	return jsErr
+}
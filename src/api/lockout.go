@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Lockout state blob layout:
+//
+//	magic[4] = "COSL"
+//	version[1]
+//	count[4]
+//	count times:
+//		credentialID<0..2^16-1>
+//		failures[4]
+//		lastFailure[8]
+//		lockedUntil[8]
+//
+// All integers are big-endian, times are in unix milliseconds and a zero lockedUntil means not locked.
+const (
+	lockoutMagic   = "COSL"
+	lockoutVersion = 1
+)
+
+const (
+	// failureMemory is how long the failures of a credential identifier that is not locked are
+	// remembered after the last one, unless its backoff lasts longer.
+	failureMemory = 24 * time.Hour
+	// maxLockoutRecords bounds the number of credential identifiers with failures tracked by one server.
+	maxLockoutRecords = 1 << 16
+)
+
+var (
+	errLockoutNotSet       = newError(codeNotInitialized, "lockout policy must be set first")
+	errInvalidLockoutState = &apiError{code: codeDecode, argName: "state", msg: "invalid lockout state"}
+)
+
+// lockoutPolicy tells when a credential identifier is refused after failed logins.
+type lockoutPolicy struct {
+	maxFailures int             // failures until lockout, 0 means never locked
+	backoff     []time.Duration // wait after the n-th failure, the last one repeats
+	lockout     time.Duration   // lockout duration, 0 means until reset
+}
+
+type failureRecord struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time // zero when not locked
+	locked      bool
+}
+
+// lockoutTracker counts the failed logins of every credential identifier under a lockout policy.
+// Logins are attributed to their credential identifier by the hash of the expected client MAC
+// of the login state, so only logins started by the same server instance are counted.
+// A client with a wrong password never sends a KE3, so a login that is not finished before its
+// state expires counts as a failure too, as does a pending login dropped to make room for others.
+// Records are forgotten once they no longer refuse logins and failureMemory has passed, and at most
+// maxLockoutRecords are kept, those of the locked credential identifiers last.
+// It is safe for concurrent use.
+type lockoutTracker struct {
+	mu        sync.Mutex
+	policy    *lockoutPolicy // nil means no policy
+	records   map[string]*failureRecord
+	nextSweep int                   // number of records at which the stale ones are swept
+	pending   pendingLogins[string] // credential identifiers of the pending logins
+}
+
+// setPolicy sets the lockout policy. A nil policy turns tracking off and forgets every record.
+func (lt *lockoutTracker) setPolicy(policy *lockoutPolicy) error {
+	if policy != nil {
+		if policy.maxFailures < 0 {
+			return argError("maxFailures", "maxFailures must not be negative")
+		}
+
+		if policy.lockout < 0 {
+			return argError("lockoutMs", "lockoutMs must not be negative")
+		}
+
+		for _, wait := range policy.backoff {
+			if wait < 0 {
+				return argError("backoffMs", "backoffMs must not contain negative values")
+			}
+		}
+	}
+
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	lt.policy = policy
+	if policy == nil {
+		lt.records = nil
+		lt.nextSweep = 0
+		lt.pending.clear()
+	}
+
+	return nil
+}
+
+// check fails with ERR_LOCKED when logins of credID are refused at now.
+func (lt *lockoutTracker) check(credID string, now time.Time) error {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if lt.policy == nil {
+		return nil
+	}
+
+	lt.expire(now)
+
+	rec, ok := lt.records[credID]
+	if !ok {
+		return nil
+	}
+
+	if lt.isStale(rec, now) {
+		delete(lt.records, credID)
+		return nil
+	}
+
+	if rec.locked {
+		return newError(codeLocked, "credential is locked")
+	}
+
+	if retryAt := lt.retryAt(rec); now.Before(retryAt) {
+		return newError(codeLocked, fmt.Sprintf("too many failed logins, retry in %d ms", retryAt.Sub(now).Milliseconds()))
+	}
+
+	return nil
+}
+
+// retryAt returns when the backoff after the last failure of rec ends. It must be called with lt.mu held.
+func (lt *lockoutTracker) retryAt(rec *failureRecord) time.Time {
+	if len(lt.policy.backoff) == 0 || rec.failures == 0 {
+		return rec.lastFailure
+	}
+
+	step := rec.failures - 1
+	if step >= len(lt.policy.backoff) {
+		step = len(lt.policy.backoff) - 1
+	}
+
+	return rec.lastFailure.Add(lt.policy.backoff[step])
+}
+
+// isStale reports whether rec no longer refuses logins at now and can be forgotten:
+// its lockout is over, or it is not locked and both its backoff and failureMemory have passed
+// since the last failure. It must be called with lt.mu held.
+func (lt *lockoutTracker) isStale(rec *failureRecord, now time.Time) bool {
+	if rec.locked {
+		return !rec.lockedUntil.IsZero() && !now.Before(rec.lockedUntil)
+	}
+
+	forgetAt := rec.lastFailure.Add(failureMemory)
+	if retryAt := lt.retryAt(rec); retryAt.After(forgetAt) {
+		forgetAt = retryAt
+	}
+
+	return !now.Before(forgetAt)
+}
+
+// begin attributes the login with the given expected client MAC to credID until expires.
+func (lt *lockoutTracker) begin(expectedClientMac []byte, credID string, now, expires time.Time) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if lt.policy == nil {
+		return
+	}
+
+	lt.pending.add(expectedClientMac, credID, expires, now, lt.recordFailure)
+}
+
+// finish records the outcome of the login with the given expected client MAC.
+// A successful login clears the failures of its credential identifier.
+func (lt *lockoutTracker) finish(expectedClientMac []byte, succeeded bool, now time.Time) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if lt.policy == nil {
+		return
+	}
+
+	credID, _, ok := lt.pending.take(expectedClientMac)
+	if !ok {
+		return
+	}
+
+	if succeeded {
+		delete(lt.records, credID)
+		return
+	}
+
+	lt.recordFailure(credID, now)
+}
+
+// expire counts the pending logins expired at now as failures. It must be called with lt.mu held.
+func (lt *lockoutTracker) expire(now time.Time) {
+	lt.pending.expire(now, lt.recordFailure)
+}
+
+// recordFailure must be called with lt.mu held.
+func (lt *lockoutTracker) recordFailure(credID string, at time.Time) {
+	if lt.records == nil {
+		lt.records = make(map[string]*failureRecord)
+	}
+
+	rec, ok := lt.records[credID]
+	if !ok {
+		lt.makeRoom(at)
+		rec = &failureRecord{}
+		lt.records[credID] = rec
+	}
+
+	if rec.locked {
+		if rec.lockedUntil.IsZero() || at.Before(rec.lockedUntil) {
+			return
+		}
+		*rec = failureRecord{}
+	}
+
+	rec.failures++
+	rec.lastFailure = at
+
+	if lt.policy.maxFailures > 0 && rec.failures >= lt.policy.maxFailures {
+		rec.locked = true
+		if lt.policy.lockout > 0 {
+			rec.lockedUntil = at.Add(lt.policy.lockout)
+		}
+	}
+}
+
+// makeRoom sweeps the stale records once their number has doubled since the last sweep, and drops
+// the oldest records when maxLockoutRecords are kept, those not locked first. It must be called
+// with lt.mu held.
+func (lt *lockoutTracker) makeRoom(now time.Time) {
+	if len(lt.records) >= lt.nextSweep {
+		for credID, rec := range lt.records {
+			if lt.isStale(rec, now) {
+				delete(lt.records, credID)
+			}
+		}
+
+		lt.nextSweep = 2 * len(lt.records)
+		if lt.nextSweep < 1024 {
+			lt.nextSweep = 1024
+		}
+	}
+
+	if len(lt.records) < maxLockoutRecords {
+		return
+	}
+
+	// Drop a sixteenth at once, so that a flood of failures does not sort the records every time.
+	credIDs := make([]string, 0, len(lt.records))
+	for credID := range lt.records {
+		credIDs = append(credIDs, credID)
+	}
+
+	sort.Slice(credIDs, func(i, j int) bool {
+		a, b := lt.records[credIDs[i]], lt.records[credIDs[j]]
+		if a.locked != b.locked {
+			return !a.locked
+		}
+		return a.lastFailure.Before(b.lastFailure)
+	})
+
+	for _, credID := range credIDs[:maxLockoutRecords/16] {
+		delete(lt.records, credID)
+	}
+}
+
+// reset clears the failures and the lockout of credID.
+func (lt *lockoutTracker) reset(credID string) error {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if lt.policy == nil {
+		return errLockoutNotSet
+	}
+
+	delete(lt.records, credID)
+	return nil
+}
+
+// clear turns tracking off and forgets every record.
+func (lt *lockoutTracker) clear() {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	lt.policy = nil
+	lt.records = nil
+	lt.nextSweep = 0
+	lt.pending.clear()
+}
+
+// export serializes the failure records into the lockout state blob.
+func (lt *lockoutTracker) export() ([]byte, error) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if lt.policy == nil {
+		return nil, errLockoutNotSet
+	}
+
+	credIDs := make([]string, 0, len(lt.records))
+	for credID := range lt.records {
+		credIDs = append(credIDs, credID)
+	}
+	sort.Strings(credIDs)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(lockoutMagic)
+	buf.WriteByte(lockoutVersion)
+
+	if err := binary.Write(buf, binary.BigEndian, uint32(len(credIDs))); err != nil {
+		return nil, err
+	}
+
+	for _, credID := range credIDs {
+		rec := lt.records[credID]
+
+		if err := writeVector(buf, []byte(credID)); err != nil {
+			return nil, err
+		}
+
+		var lockedUntil int64
+		if rec.locked {
+			// A lockout until reset is stored as the largest time.
+			lockedUntil = 1<<63 - 1
+			if !rec.lockedUntil.IsZero() {
+				lockedUntil = rec.lockedUntil.UnixMilli()
+			}
+		}
+
+		fields := []any{uint32(rec.failures), rec.lastFailure.UnixMilli(), lockedUntil}
+		for _, field := range fields {
+			if err := binary.Write(buf, binary.BigEndian, field); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// load replaces the failure records with the ones of a lockout state blob.
+func (lt *lockoutTracker) load(data []byte) error {
+	r := bytes.NewReader(data)
+
+	magic := make([]byte, len(lockoutMagic))
+	if _, err := r.Read(magic); err != nil || string(magic) != lockoutMagic {
+		return fmt.Errorf("%w: unknown format", errInvalidLockoutState)
+	}
+
+	version, err := r.ReadByte()
+	if err != nil || version != lockoutVersion {
+		return fmt.Errorf("%w: unsupported version", errInvalidLockoutState)
+	}
+
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return fmt.Errorf("%w: missing count", errInvalidLockoutState)
+	}
+
+	records := make(map[string]*failureRecord)
+
+	for i := uint32(0); i < count; i++ {
+		credID, err := readVector(r)
+		if err != nil {
+			return fmt.Errorf("%w: truncated data", errInvalidLockoutState)
+		}
+
+		var entry struct {
+			Failures    uint32
+			LastFailure int64
+			LockedUntil int64
+		}
+		if err := binary.Read(r, binary.BigEndian, &entry); err != nil {
+			return fmt.Errorf("%w: truncated data", errInvalidLockoutState)
+		}
+
+		rec := &failureRecord{failures: int(entry.Failures), lastFailure: time.UnixMilli(entry.LastFailure)}
+		if entry.LockedUntil != 0 {
+			rec.locked = true
+			if entry.LockedUntil != 1<<63-1 {
+				rec.lockedUntil = time.UnixMilli(entry.LockedUntil)
+			}
+		}
+
+		records[string(credID)] = rec
+	}
+
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: trailing data", errInvalidLockoutState)
+	}
+
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if lt.policy == nil {
+		return errLockoutNotSet
+	}
+
+	lt.records = records
+	return nil
+}
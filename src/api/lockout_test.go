@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// lockoutStep is one login attempt of a lockout test, made after advance, and after resetting
+// the lockout of the credential identifier when reset is set. A failed attempt sends a wrong KE3.
+type lockoutStep struct {
+	advance  time.Duration
+	reset    bool
+	fail     bool
+	wantCode errorCode
+}
+
+// TestLockout runs series of failed and successful logins of one credential identifier under
+// lockout policies and checks which ones are refused as locked.
+func TestLockout(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy lockoutPolicy
+		steps  []lockoutStep
+	}{
+		{
+			name:   "locked at threshold until reset",
+			policy: lockoutPolicy{maxFailures: 3},
+			steps: []lockoutStep{
+				{fail: true, wantCode: codeAuthFailed},
+				{fail: true, wantCode: codeAuthFailed},
+				{fail: true, wantCode: codeAuthFailed},
+				{wantCode: codeLocked},
+				{advance: 48 * time.Hour, wantCode: codeLocked},
+				{reset: true},
+			},
+		},
+		{
+			name:   "locked at threshold for the lockout duration",
+			policy: lockoutPolicy{maxFailures: 2, lockout: time.Hour},
+			steps: []lockoutStep{
+				{fail: true, wantCode: codeAuthFailed},
+				{fail: true, wantCode: codeAuthFailed},
+				{advance: 59 * time.Minute, wantCode: codeLocked},
+				{advance: time.Minute},
+			},
+		},
+		{
+			name:   "success clears the failures",
+			policy: lockoutPolicy{maxFailures: 3},
+			steps: []lockoutStep{
+				{fail: true, wantCode: codeAuthFailed},
+				{fail: true, wantCode: codeAuthFailed},
+				{},
+				{fail: true, wantCode: codeAuthFailed},
+				{fail: true, wantCode: codeAuthFailed},
+				{},
+			},
+		},
+		{
+			name:   "reset clears the failures",
+			policy: lockoutPolicy{maxFailures: 2},
+			steps: []lockoutStep{
+				{fail: true, wantCode: codeAuthFailed},
+				{reset: true, fail: true, wantCode: codeAuthFailed},
+				{},
+			},
+		},
+		{
+			name:   "backoff after each failure",
+			policy: lockoutPolicy{backoff: []time.Duration{time.Second, time.Minute}},
+			steps: []lockoutStep{
+				{fail: true, wantCode: codeAuthFailed},
+				{wantCode: codeLocked},
+				{advance: time.Second, fail: true, wantCode: codeAuthFailed},
+				{advance: 59 * time.Second, wantCode: codeLocked},
+				{advance: time.Second},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		now := time.Unix(1700000000, 0)
+
+		sv, cl, record := newTestLogin(t)
+		sv.timer.setClock(func() time.Time { return now })
+
+		policy := tt.policy
+		if err := sv.lockout.setPolicy(&policy); err != nil {
+			t.Fatal(err)
+		}
+
+		for i, step := range tt.steps {
+			now = now.Add(step.advance)
+
+			if step.reset {
+				if err := sv.lockout.reset("alice"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := attemptLogin(t, sv, cl, record, step.fail); errorCodeOf(err) != step.wantCode {
+				t.Errorf("%s: step %d: got %v, want code %q", tt.name, i, err, step.wantCode)
+			}
+		}
+	}
+}
+
+// attemptLogin logs "alice" in, sending a wrong KE3 when fail is set, and returns the error of the
+// server.
+func attemptLogin(t *testing.T, sv *server, cl *client, record []byte, fail bool) error {
+	t.Helper()
+
+	loginState, ke1, err := cl.LoginInit("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svState, ke2, err := sv.LoginInit(record, ke1, nil, "alice", "alice")
+	if err != nil {
+		return err
+	}
+
+	ke3, _, _, err := cl.LoginFinish(loginState, ke2, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fail {
+		ke3[len(ke3)-1] ^= 1
+	}
+
+	_, err = sv.LoginFinish(svState, ke3)
+	return err
+}
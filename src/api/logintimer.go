@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+var errLoginTimeout = newError(codeTimeout, "login timed out")
+
+// loginTimer enforces the login timeout of a server. While a timeout is set it
+// records the deadline of every login state the server hands out, keyed by the
+// hash of the expected client MAC, and loginFinish must come before it. Past maxPendingLogins
+// the login closest to its deadline is dropped and times out early. It is safe for concurrent use.
+type loginTimer struct {
+	mu      sync.Mutex
+	timeout time.Duration // 0 means no timeout
+	clock   func() time.Time
+	pending pendingLogins[struct{}]
+}
+
+// now returns the current time of the clock.
+func (lt *loginTimer) now() time.Time {
+	lt.mu.Lock()
+	clock := lt.clock
+	lt.mu.Unlock()
+
+	if clock == nil {
+		return time.Now()
+	}
+
+	return clock()
+}
+
+// setClock replaces the clock. A nil clock restores the system clock.
+func (lt *loginTimer) setClock(clock func() time.Time) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	lt.clock = clock
+}
+
+// setTimeout sets the login timeout. Zero removes the timeout and forgets the pending logins.
+func (lt *loginTimer) setTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return argError("timeout", "timeout must not be negative")
+	}
+
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	lt.timeout = timeout
+	if timeout == 0 {
+		lt.pending.clear()
+	}
+
+	return nil
+}
+
+// lifetime returns how long login states handed out now stay valid.
+func (lt *loginTimer) lifetime() time.Duration {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if lt.timeout == 0 {
+		return sessionLifetime
+	}
+
+	return lt.timeout
+}
+
+// start records the deadline of a login with the given expected client MAC.
+func (lt *loginTimer) start(expectedClientMac []byte, now time.Time) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if lt.timeout == 0 {
+		return
+	}
+
+	lt.pending.add(expectedClientMac, struct{}{}, now.Add(lt.timeout), now, nil)
+}
+
+// finish forgets the login with the given expected client MAC and fails with errLoginTimeout
+// when its deadline has passed. A login that is not tracked fails as well unless its state
+// was sealed, because the sealed state carries its own deadline.
+func (lt *loginTimer) finish(expectedClientMac []byte, sealed bool, now time.Time) error {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if lt.timeout == 0 {
+		return nil
+	}
+
+	_, deadline, ok := lt.pending.take(expectedClientMac)
+	if !ok {
+		if sealed {
+			return nil
+		}
+		return errLoginTimeout
+	}
+
+	if !now.Before(deadline) {
+		return errLoginTimeout
+	}
+
+	return nil
+}
+
+// clear forgets the pending logins and restores the defaults.
+func (lt *loginTimer) clear() {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	lt.timeout = 0
+	lt.clock = nil
+	lt.pending.clear()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLoginTimeout finishes logins before and after the login timeout, with sealed and
+// unsealed states and with strict mode, which must report the timeout as well.
+func TestLoginTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		sealed   bool
+		strict   bool
+		elapsed  time.Duration
+		wantCode errorCode
+	}{
+		{name: "unsealed in time", elapsed: 59 * time.Second},
+		{name: "unsealed expired", elapsed: time.Minute, wantCode: codeTimeout},
+		{name: "sealed in time", sealed: true, elapsed: 59 * time.Second},
+		{name: "sealed expired", sealed: true, elapsed: time.Minute, wantCode: codeTimeout},
+		{name: "strict in time", strict: true, elapsed: 59 * time.Second},
+		{name: "strict expired", strict: true, elapsed: time.Minute, wantCode: codeTimeout},
+		{name: "strict sealed expired", strict: true, sealed: true, elapsed: time.Minute, wantCode: codeTimeout},
+	}
+
+	for _, tt := range tests {
+		now := time.Unix(1700000000, 0)
+
+		sv, cl, record := newTestLogin(t)
+		sv.timer.setClock(func() time.Time { return now })
+		sv.logins.setStrict(tt.strict)
+
+		if err := sv.timer.setTimeout(time.Minute); err != nil {
+			t.Fatal(err)
+		}
+
+		if tt.sealed {
+			if _, err := sv.sealer.setKey(nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		loginState, ke1, err := cl.LoginInit("password")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		svState, ke2, err := sv.LoginInit(record, ke1, nil, "alice", "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ke3, _, _, err := cl.LoginFinish(loginState, ke2, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		now = now.Add(tt.elapsed)
+
+		if _, err := sv.LoginFinish(svState, ke3); errorCodeOf(err) != tt.wantCode {
+			t.Errorf("%s: got %v, want code %q", tt.name, err, tt.wantCode)
+		}
+	}
+}
+
+// newTestLogin returns a server with a bound oprf seed, a client and the record of the client
+// identity "alice" registered with the password "password".
+func newTestLogin(t *testing.T) (*server, *client, []byte) {
+	t.Helper()
+
+	sv := newServer()
+	if err := sv.InitializeServer("Ristretto255Suite", "example.com", nil, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	cl := newClient()
+	if err := cl.InitializeClient("Ristretto255Suite", "example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	regState, regReq, err := cl.RegistrationInit("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	regRes, err := sv.RegistrationEval(regReq, nil, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, _, err := cl.RegistrationFinalize(regState, regRes, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sv, cl, record
+}
@@ -1,3 +1,5 @@
+//go:build js && wasm
+
 package main
 
 import (
@@ -13,12 +15,17 @@ func main() {
 
 	clMan := newClientManager()
 	svMan := newServerManager()
+	chMan := newChannelManager()
+	tnMan := newTenantManager()
 
 	js.Global().Set(rootEl, make(map[string]interface{}))
 	rootModule := js.Global().Get(rootEl)
 
 	clMan.exposeToJS(rootModule)
 	svMan.exposeServer(rootModule)
+	chMan.exposeToJS(rootModule)
+	tnMan.exposeToJS(rootModule)
+	exposeUtils(rootModule)
 
 	<-done
 }
@@ -0,0 +1,7 @@
+//go:build !(js && wasm)
+
+package main
+
+// The module only runs as js/wasm, see main.go. This stub lets the protocol code build
+// and be tested on the host as well, where the race detector is available.
+func main() {}
@@ -0,0 +1,33 @@
+package main
+
+import "github.com/cymony/cryptomony/opaque"
+
+// decodeKE1 decodes a KE1 message.
+// opaque.KE1.Decode reports success for truncated input, so the decoded fields are checked here.
+func decodeKE1(suite opaque.Suite, data []byte) (*opaque.KE1, error) {
+	ke1 := &opaque.KE1{}
+	if err := ke1.Decode(suite, data); err != nil {
+		return nil, decodeError("ke1", err)
+	}
+
+	if ke1.CredentialRequest == nil || ke1.AuthRequest == nil {
+		return nil, decodeError("ke1", opaque.ErrDecodingFailed)
+	}
+
+	return ke1, nil
+}
+
+// decodeKE2 decodes a KE2 message.
+// opaque.KE2.Decode reports success for truncated input, so the decoded fields are checked here.
+func decodeKE2(suite opaque.Suite, data []byte) (*opaque.KE2, error) {
+	ke2 := &opaque.KE2{}
+	if err := ke2.Decode(suite, data); err != nil {
+		return nil, decodeError("ke2Message", err)
+	}
+
+	if ke2.CredentialResponse == nil || ke2.AuthResponse == nil {
+		return nil, decodeError("ke2Message", opaque.ErrDecodingFailed)
+	}
+
+	return ke2, nil
+}
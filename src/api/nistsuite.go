@@ -0,0 +1,294 @@
+package main
+
+import (
+	"github.com/cymony/cryptomony/eccgroup"
+	"github.com/cymony/cryptomony/hash"
+	"github.com/cymony/cryptomony/ksf"
+	"github.com/cymony/cryptomony/opaque"
+	"github.com/cymony/cryptomony/oprf"
+	"github.com/cymony/cryptomony/utils"
+)
+
+// Identifiers of the suites implemented here, which newSuite builds instead of Identifier.New.
+// They are local to this module and taken from the top of the 16 bit range the blobs store suites in,
+// away from the identifiers of cryptomony, so that a suite cryptomony adds later never decodes as one of them.
+const (
+	localSuiteBase opaque.Identifier = 0xff00
+
+	p384SuiteID = localSuiteBase + 1 // OPRF(P-384, SHA-384), HKDF-SHA-384, HMAC-SHA-384, SHA-384, Scrypt(32768,8,1), internal, P-384
+	p521SuiteID = localSuiteBase + 2 // OPRF(P-521, SHA-512), HKDF-SHA-512, HMAC-SHA-512, SHA-512, Scrypt(32768,8,1), internal, P-521
+)
+
+// nistContext is the context of the AKE preamble, the one cryptomony uses for its own suites.
+var nistContext = "cryptomonyOPAQUE-v1.0.0"
+
+var (
+	labelNistOprfKey               = "OprfKey"
+	labelNistMaskingKey            = "MaskingKey"
+	labelNistAuthKey               = "AuthKey"
+	labelNistExportKey             = "ExportKey"
+	labelNistPrivateKey            = "PrivateKey"
+	labelNistCredentialResponsePad = "CredentialResponsePad"
+	labelNistDeriveKeyPair         = "OPAQUE-DeriveKeyPair"
+	labelNistDeriveAuthKeyPair     = "OPAQUE-DeriveAuthKeyPair"
+)
+
+// newSuite builds the suite of suiteID. suiteID must be a supported suite.
+func newSuite(suiteID opaque.Identifier) opaque.Suite {
+	switch suiteID {
+	case p384SuiteID:
+		return &nistSuite{oprf: oprf.SuiteP384Sha384, group: eccgroup.P384Sha384, hsh: hash.SHA384, ksf: ksf.Scrypt}
+	case p521SuiteID:
+		return &nistSuite{oprf: oprf.SuiteP521Sha512, group: eccgroup.P521Sha512, hsh: hash.SHA512, ksf: ksf.Scrypt}
+	default:
+		return suiteID.New()
+	}
+}
+
+// nistSuite is an OPAQUE-3DH suite over one of the larger NIST curves, built from the cryptomony
+// OPRF suites and groups. It follows the cryptomony suites step for step, so over P-256 it would
+// produce the same messages, with one exception: the OPRF key seed is Nseed bytes long, as in the
+// final specification, where cryptomony takes Nok bytes, which only agree for 32 byte scalars.
+// Reference: https://www.ietf.org/archive/id/draft-irtf-cfrg-opaque-09.html
+type nistSuite struct {
+	oprf  oprf.Suite
+	group eccgroup.Group
+	hsh   hash.Hashing // KDF, MAC and hash of the suite
+	ksf   ksf.Identifier
+}
+
+func (ns *nistSuite) OPRF() oprf.Suite {
+	return ns.oprf
+}
+
+func (ns *nistSuite) Group() eccgroup.Group {
+	return ns.group
+}
+
+func (ns *nistSuite) Hash() hash.Hash {
+	return ns.hsh.New()
+}
+
+func (ns *nistSuite) Expand(pseudorandomKey, info []byte, length int) []byte {
+	return ns.hsh.New().HKDFExpand(pseudorandomKey, info, length)
+}
+
+func (ns *nistSuite) Extract(salt, secret []byte) []byte {
+	return ns.hsh.New().HKDFExtract(secret, salt)
+}
+
+func (ns *nistSuite) MAC(key, message []byte) ([]byte, error) {
+	return ns.hsh.New().Hmac(message, key)
+}
+
+func (ns *nistSuite) Stretch(password []byte, length int) ([]byte, error) {
+	return ns.ksf.New().Harden(password, nil, length)
+}
+
+func (ns *nistSuite) GenerateOprfSeed() []byte {
+	return utils.RandomBytes(ns.Nh())
+}
+
+func (ns *nistSuite) Nh() int {
+	return ns.hsh.New().OutputSize()
+}
+
+func (ns *nistSuite) Npk() int {
+	return int(ns.group.ElementLength())
+}
+
+func (ns *nistSuite) Nsk() int {
+	return int(ns.group.ScalarLength())
+}
+
+func (ns *nistSuite) Nm() int {
+	return ns.hsh.CryptoID().Size()
+}
+
+func (ns *nistSuite) Nx() int {
+	return ns.hsh.CryptoID().Size()
+}
+
+func (ns *nistSuite) Noe() int {
+	return int(ns.group.ElementLength())
+}
+
+func (ns *nistSuite) Nok() int {
+	return int(ns.group.ScalarLength())
+}
+
+func (ns *nistSuite) Nn() int {
+	return 32
+}
+
+func (ns *nistSuite) Nseed() int {
+	return 32
+}
+
+func (ns *nistSuite) Ne() int {
+	return ns.Nn() + ns.Nm()
+}
+
+func (ns *nistSuite) DeriveKeyPair(seed []byte) (*opaque.PrivateKey, error) {
+	return ns.deriveKey(seed, labelNistDeriveKeyPair)
+}
+
+func (ns *nistSuite) GenerateKeyPair() (*opaque.PrivateKey, error) {
+	return ns.DeriveKeyPair(utils.RandomBytes(ns.Nseed()))
+}
+
+func (ns *nistSuite) DeriveAuthKeyPair(seed []byte) (*opaque.PrivateKey, error) {
+	return ns.deriveKey(seed, labelNistDeriveAuthKeyPair)
+}
+
+func (ns *nistSuite) GenerateAuthKeyPair() (*opaque.PrivateKey, error) {
+	return ns.DeriveAuthKeyPair(utils.RandomBytes(ns.Nseed()))
+}
+
+func (ns *nistSuite) deriveKey(seed []byte, info string) (*opaque.PrivateKey, error) {
+	if len(seed) != ns.Nseed() {
+		return nil, opaque.ErrSeedLength
+	}
+
+	oprfKey, err := oprf.DeriveKey(ns.oprf, oprf.ModeOPRF, seed, []byte(info))
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := oprfKey.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	privKey := &opaque.PrivateKey{}
+	if err := privKey.UnmarshalBinary(ns, encoded); err != nil {
+		return nil, err
+	}
+
+	return privKey, nil
+}
+
+// blind blinds password with the chosen blind and returns the blind and the blinded element.
+func (ns *nistSuite) blind(password []byte, chosenBlind *eccgroup.Scalar) (*eccgroup.Scalar, *eccgroup.Element, error) {
+	oprfClient, err := oprf.NewClient(ns.oprf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	finData, evalReq, err := oprfClient.DeterministicBlind([][]byte{password}, []*eccgroup.Scalar{chosenBlind})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(finData.Blinds) != 1 || len(evalReq.BlindedElements) != 1 {
+		return nil, nil, opaque.ErrOPRFBlind
+	}
+
+	return finData.Blinds[0], evalReq.BlindedElements[0], nil
+}
+
+// finalize unblinds the evaluated element and returns the OPRF output for password.
+func (ns *nistSuite) finalize(evaluatedEl *eccgroup.Element, password []byte, blind *eccgroup.Scalar) ([]byte, error) {
+	oprfClient, err := oprf.NewClient(ns.oprf)
+	if err != nil {
+		return nil, err
+	}
+
+	finData := &oprf.FinalizeData{
+		Inputs:      [][]byte{password},
+		Blinds:      []*eccgroup.Scalar{blind},
+		EvalRequest: &oprf.EvaluationRequest{},
+	}
+
+	outputs, err := oprfClient.Finalize(finData, &oprf.EvaluationResponse{EvaluatedElements: []*eccgroup.Element{evaluatedEl}})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(outputs) != 1 {
+		return nil, opaque.ErrOPRFFinalize
+	}
+
+	return outputs[0], nil
+}
+
+// evaluate evaluates the blinded element under the OPRF key of credID.
+func (ns *nistSuite) evaluate(blindedEl *eccgroup.Element, credID, oprfSeed []byte) (*eccgroup.Element, error) {
+	if len(oprfSeed) != ns.Nh() {
+		return nil, opaque.ErrOPRFSeedLength
+	}
+
+	// seed = Expand(oprf_seed, concat(credential_identifier, "OprfKey"), Nseed)
+	seed := ns.Expand(oprfSeed, utils.Concat(credID, []byte(labelNistOprfKey)), ns.Nseed())
+	defer wipeBytes(seed)
+
+	// (oprf_key, _) = DeriveKeyPair(seed, "OPAQUE-DeriveKeyPair")
+	oprfKey, err := oprf.DeriveKey(ns.oprf, oprf.ModeOPRF, seed, []byte(labelNistDeriveKeyPair))
+	if err != nil {
+		return nil, err
+	}
+
+	oprfServer, err := oprf.NewServer(ns.oprf, oprfKey)
+	if err != nil {
+		return nil, err
+	}
+
+	evalRes, err := oprfServer.BlindEvaluate(&oprf.EvaluationRequest{BlindedElements: []*eccgroup.Element{blindedEl}})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(evalRes.EvaluatedElements) != 1 {
+		return nil, opaque.ErrOPRFEvaluate
+	}
+
+	return evalRes.EvaluatedElements[0], nil
+}
+
+// randomizedPassword finalizes the OPRF and hardens its output into the randomized password.
+func (ns *nistSuite) randomizedPassword(evaluatedEl *eccgroup.Element, password []byte, blind *eccgroup.Scalar) ([]byte, error) {
+	// oprf_output = Finalize(password, blind, evaluated_element)
+	oprfOutput, err := ns.finalize(evaluatedEl, password, blind)
+	if err != nil {
+		return nil, err
+	}
+
+	// stretched_oprf_output = Stretch(oprf_output, params)
+	stretched, err := ns.Stretch(oprfOutput, ns.Noe())
+	if err != nil {
+		return nil, err
+	}
+
+	// randomized_pwd = Extract("", concat(oprf_output, stretched_oprf_output))
+	return ns.Extract(nil, utils.Concat(oprfOutput, stretched)), nil
+}
+
+// scalar returns the scalar of privKey.
+func (ns *nistSuite) scalar(privKey *opaque.PrivateKey) (*eccgroup.Scalar, error) {
+	encoded, err := privKey.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	sc := ns.group.NewScalar()
+	if err := sc.UnmarshalBinary(encoded); err != nil {
+		return nil, err
+	}
+
+	return sc, nil
+}
+
+// element returns the group element of pubKey.
+func (ns *nistSuite) element(pubKey *opaque.PublicKey) (*eccgroup.Element, error) {
+	encoded, err := pubKey.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	el := ns.group.NewElement()
+	if err := el.UnmarshalBinary(encoded); err != nil {
+		return nil, err
+	}
+
+	return el, nil
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"math"
+
+	"github.com/cymony/cryptomony/eccgroup"
+	"github.com/cymony/cryptomony/opaque"
+	"github.com/cymony/cryptomony/utils"
+)
+
+var (
+	labelNistPreamble        = "RFCXXXX"
+	labelNistLabelPrefix     = "OPAQUE-"
+	labelNistHandshakeSecret = "HandshakeSecret"
+	labelNistSessionKey      = "SessionKey"
+	labelNistServerMAC       = "ServerMAC"
+	labelNistClientMAC       = "ClientMAC"
+)
+
+// Registration
+
+func (ns *nistSuite) CreateRegistrationRequest(password []byte) (*opaque.RegistrationRequest, *eccgroup.Scalar, error) {
+	blind, blindedEl, err := ns.blind(password, ns.group.RandomScalar())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &opaque.RegistrationRequest{BlindedMessage: blindedEl}, blind, nil
+}
+
+func (ns *nistSuite) CreateRegistrationResponse(regReq *opaque.RegistrationRequest, serverPubKey *opaque.PublicKey, credentialIdentifier, oprfSeed []byte) (*opaque.RegistrationResponse, error) {
+	evaluatedEl, err := ns.evaluate(regReq.BlindedMessage, credentialIdentifier, oprfSeed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &opaque.RegistrationResponse{EvaluatedMessage: evaluatedEl, ServerPublicKey: serverPubKey}, nil
+}
+
+func (ns *nistSuite) FinalizeRegistrationRequest(password, serverIdentity, clientIdentity []byte, blind *eccgroup.Scalar, regRes *opaque.RegistrationResponse) (*opaque.RegistrationRecord, []byte, error) {
+	randomizedPwd, err := ns.randomizedPassword(regRes.EvaluatedMessage, password, blind)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer wipeBytes(randomizedPwd)
+
+	// (envelope, client_public_key, masking_key, export_key) = Store(randomized_pwd, response.server_public_key, server_identity, client_identity)
+	envelope, cPubKey, maskingKey, exportKey, err := ns.Store(randomizedPwd, regRes.ServerPublicKey, serverIdentity, clientIdentity)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &opaque.RegistrationRecord{ClientPubKey: cPubKey, MaskingKey: maskingKey, Envelope: envelope}, exportKey, nil
+}
+
+// Envelope
+
+func (ns *nistSuite) Store(randomizedPwd []byte, sPubKey *opaque.PublicKey, serverIdentity, clientIdentity []byte) (*opaque.Envelope, *opaque.PublicKey, []byte, []byte, error) {
+	// envelope_nonce = random(Nn)
+	envelopeNonce := utils.RandomBytes(ns.Nn())
+
+	// masking_key = Expand(randomized_pwd, "MaskingKey", Nh)
+	maskingKey := ns.Expand(randomizedPwd, []byte(labelNistMaskingKey), ns.Nh())
+
+	cPrivKey, authTag, exportKey, err := ns.openEnvelope(randomizedPwd, sPubKey, envelopeNonce, serverIdentity, clientIdentity)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	return &opaque.Envelope{Nonce: envelopeNonce, AuthTag: authTag}, cPrivKey.Public(), maskingKey, exportKey, nil
+}
+
+func (ns *nistSuite) Recover(randomizedPwd []byte, sPubKey *opaque.PublicKey, envelope *opaque.Envelope, serverIdentity, clientIdentity []byte) (*opaque.PrivateKey, []byte, error) {
+	cPrivKey, expectedTag, exportKey, err := ns.openEnvelope(randomizedPwd, sPubKey, envelope.Nonce, serverIdentity, clientIdentity)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !hmac.Equal(envelope.AuthTag, expectedTag) {
+		return nil, nil, opaque.ErrEnvelopeRecovery
+	}
+
+	return cPrivKey, exportKey, nil
+}
+
+// openEnvelope derives the client private key, the auth tag and the export key shared by Store and Recover.
+func (ns *nistSuite) openEnvelope(randomizedPwd []byte, sPubKey *opaque.PublicKey, envelopeNonce, serverIdentity, clientIdentity []byte) (*opaque.PrivateKey, []byte, []byte, error) {
+	// auth_key = Expand(randomized_pwd, concat(envelope_nonce, "AuthKey"), Nh)
+	authKey := ns.Expand(randomizedPwd, utils.Concat(envelopeNonce, []byte(labelNistAuthKey)), ns.Nh())
+	defer wipeBytes(authKey)
+
+	// export_key = Expand(randomized_pwd, concat(envelope_nonce, "ExportKey"), Nh)
+	exportKey := ns.Expand(randomizedPwd, utils.Concat(envelopeNonce, []byte(labelNistExportKey)), ns.Nh())
+
+	// seed = Expand(randomized_pwd, concat(envelope_nonce, "PrivateKey"), Nseed)
+	seed := ns.Expand(randomizedPwd, utils.Concat(envelopeNonce, []byte(labelNistPrivateKey)), ns.Nseed())
+	defer wipeBytes(seed)
+
+	// (client_private_key, client_public_key) = DeriveAuthKeyPair(seed)
+	cPrivKey, err := ns.DeriveAuthKeyPair(seed)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	sPubEncoded, err := sPubKey.MarshalBinary()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	cPubEncoded, err := cPrivKey.Public().MarshalBinary()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// cleartext_creds = CreateCleartextCredentials(server_public_key, client_public_key, server_identity, client_identity)
+	encodedCreds, err := opaque.CreateCleartextCredentials(sPubEncoded, cPubEncoded, serverIdentity, clientIdentity).Encode()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// auth_tag = MAC(auth_key, concat(envelope_nonce, cleartext_creds))
+	authTag, err := ns.MAC(authKey, utils.Concat(envelopeNonce, encodedCreds))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return cPrivKey, authTag, exportKey, nil
+}
+
+// Credential retrieval
+
+func (ns *nistSuite) CreateCredentialRequest(password []byte, chosenBlind *eccgroup.Scalar) (*opaque.CredentialRequest, *eccgroup.Scalar, error) {
+	blind, blindedEl, err := ns.blind(password, chosenBlind)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &opaque.CredentialRequest{BlindedMessage: blindedEl}, blind, nil
+}
+
+func (ns *nistSuite) CreateCredentialResponse(credReq *opaque.CredentialRequest, serverPubKey *opaque.PublicKey, record *opaque.RegistrationRecord, credIdentifier, oprfSeed, maskingNonce []byte) (*opaque.CredentialResponse, error) {
+	evaluatedEl, err := ns.evaluate(credReq.BlindedMessage, credIdentifier, oprfSeed)
+	if err != nil {
+		return nil, err
+	}
+
+	serializedSPubKey, err := serverPubKey.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	serializedEnvelope, err := record.Envelope.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	// credential_response_pad = Expand(record.masking_key, concat(masking_nonce, "CredentialResponsePad"), Npk + Ne)
+	pad := ns.Expand(record.MaskingKey, utils.Concat(maskingNonce, []byte(labelNistCredentialResponsePad)), ns.Npk()+ns.Ne())
+
+	// masked_response = xor(credential_response_pad, concat(server_public_key, record.envelope))
+	return &opaque.CredentialResponse{
+		EvaluatedMessage: evaluatedEl,
+		MaskingNonce:     maskingNonce,
+		MaskedResponse:   xorBytes(pad, utils.Concat(serializedSPubKey, serializedEnvelope)),
+	}, nil
+}
+
+func (ns *nistSuite) RecoverCredentials(password []byte, blind *eccgroup.Scalar, credRes *opaque.CredentialResponse, serverIdentity, clientIdentity []byte) (*opaque.PrivateKey, *opaque.PublicKey, []byte, error) {
+	randomizedPwd, err := ns.randomizedPassword(credRes.EvaluatedMessage, password, blind)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer wipeBytes(randomizedPwd)
+
+	// masking_key = Expand(randomized_pwd, "MaskingKey", Nh)
+	maskingKey := ns.Expand(randomizedPwd, []byte(labelNistMaskingKey), ns.Nh())
+	defer wipeBytes(maskingKey)
+
+	// credential_response_pad = Expand(masking_key, concat(response.masking_nonce, "CredentialResponsePad"), Npk + Ne)
+	pad := ns.Expand(maskingKey, utils.Concat(credRes.MaskingNonce, []byte(labelNistCredentialResponsePad)), ns.Npk()+ns.Ne())
+
+	// concat(server_public_key, envelope) = xor(credential_response_pad, response.masked_response)
+	if len(credRes.MaskedResponse) != len(pad) {
+		return nil, nil, nil, opaque.ErrRecoverCredentialsFailed
+	}
+
+	unmasked := xorBytes(pad, credRes.MaskedResponse)
+
+	sPubKey := &opaque.PublicKey{}
+	if err := sPubKey.UnmarshalBinary(ns, unmasked[:ns.Npk()]); err != nil {
+		return nil, nil, nil, err
+	}
+
+	envelope := &opaque.Envelope{}
+	if err := envelope.Deserialize(ns, unmasked[ns.Npk():]); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// (client_private_key, export_key) = Recover(randomized_pwd, server_public_key, envelope, server_identity, client_identity)
+	cPrivKey, exportKey, err := ns.Recover(randomizedPwd, sPubKey, envelope, serverIdentity, clientIdentity)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return cPrivKey, sPubKey, exportKey, nil
+}
+
+// AKE
+
+func (ns *nistSuite) ClientInit(password []byte) (*opaque.ClientLoginState, *opaque.KE1, error) {
+	credReq, blind, err := ns.CreateCredentialRequest(password, ns.group.RandomScalar())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// (client_secret, client_keyshare) = GenerateAuthKeyPair()
+	clientSecret, err := ns.GenerateAuthKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// client_nonce = random(Nn)
+	state, ke1, err := ns.AuthClientStart(credReq, utils.RandomBytes(ns.Nn()), clientSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state.Password = password
+	state.Blind = blind
+
+	return state, ke1, nil
+}
+
+func (ns *nistSuite) ServerInit(serverPrivKey *opaque.PrivateKey, serverPubKey *opaque.PublicKey, record *opaque.RegistrationRecord, ke1 *opaque.KE1, credIdentifier, clientIdentity, serverIdentity, oprfSeed []byte) (*opaque.ServerLoginState, *opaque.KE2, error) {
+	// masking_nonce = random(Nn)
+	credRes, err := ns.CreateCredentialResponse(ke1.CredentialRequest, serverPubKey, record, credIdentifier, oprfSeed, utils.RandomBytes(ns.Nn()))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if clientIdentity == nil {
+		if clientIdentity, err = record.ClientPubKey.MarshalBinary(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if serverIdentity == nil {
+		if serverIdentity, err = serverPubKey.MarshalBinary(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// (server_private_keyshare, server_keyshare) = GenerateAuthKeyPair()
+	serverPrivKeyshare, err := ns.GenerateAuthKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// server_nonce = random(Nn)
+	state, authRes, err := ns.AuthServerRespond(serverPrivKey, serverIdentity, clientIdentity, utils.RandomBytes(ns.Nn()), record.ClientPubKey, ke1, credRes, serverPrivKeyshare)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return state, &opaque.KE2{CredentialResponse: credRes, AuthResponse: authRes}, nil
+}
+
+func (ns *nistSuite) ClientFinish(state *opaque.ClientLoginState, clientIdentity, serverIdentity []byte, ke2 *opaque.KE2) (*opaque.KE3, []byte, []byte, error) {
+	cPrivKey, sPubKey, exportKey, err := ns.RecoverCredentials(state.Password, state.Blind, ke2.CredentialResponse, serverIdentity, clientIdentity)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if clientIdentity == nil {
+		if clientIdentity, err = cPrivKey.Public().MarshalBinary(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if serverIdentity == nil {
+		if serverIdentity, err = sPubKey.MarshalBinary(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	ke3, sessionKey, err := ns.AuthClientFinalize(state, clientIdentity, serverIdentity, cPrivKey, sPubKey, ke2)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return ke3, sessionKey, exportKey, nil
+}
+
+func (ns *nistSuite) ServerFinish(state *opaque.ServerLoginState, ke3 *opaque.KE3) ([]byte, error) {
+	return ns.AuthServerFinalize(state, ke3)
+}
+
+// 3DH
+
+func (ns *nistSuite) AuthClientStart(credentialReq *opaque.CredentialRequest, clientNonce []byte, clientSecret *opaque.PrivateKey) (*opaque.ClientLoginState, *opaque.KE1, error) {
+	ke1 := &opaque.KE1{
+		CredentialRequest: credentialReq,
+		AuthRequest:       &opaque.AuthRequest{ClientNonce: clientNonce, ClientKeyshare: clientSecret.Public()},
+	}
+
+	return &opaque.ClientLoginState{ClientSecret: clientSecret, KE1: ke1}, ke1, nil
+}
+
+func (ns *nistSuite) AuthClientFinalize(state *opaque.ClientLoginState, clientIdentity, serverIdentity []byte, cPrivKey *opaque.PrivateKey, sPubKey *opaque.PublicKey, ke2 *opaque.KE2) (*opaque.KE3, []byte, error) {
+	// dh1 = SerializeElement(state.client_secret * ke2.auth_response.server_keyshare)
+	// dh2 = SerializeElement(state.client_secret * server_public_key)
+	// dh3 = SerializeElement(client_private_key * ke2.auth_response.server_keyshare)
+	ikm, err := ns.tripleDH(
+		state.ClientSecret, ke2.AuthResponse.ServerKeyshare,
+		state.ClientSecret, sPubKey,
+		cPrivKey, ke2.AuthResponse.ServerKeyshare)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer wipeBytes(ikm)
+
+	serverMAC, clientMAC, sessionKey, err := ns.deriveMACs(ikm, clientIdentity, state.KE1, serverIdentity, ke2.CredentialResponse, ke2.AuthResponse)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !hmac.Equal(ke2.AuthResponse.ServerMAC, serverMAC) {
+		return nil, nil, opaque.ErrServerAuthentication
+	}
+
+	return &opaque.KE3{ClientMAC: clientMAC}, sessionKey, nil
+}
+
+func (ns *nistSuite) AuthServerRespond(serverPrivKey *opaque.PrivateKey, serverIdentity, clientIdentity, serverNonce []byte, clientPubKey *opaque.PublicKey, ke1 *opaque.KE1, credentialRes *opaque.CredentialResponse, serverPrivateKeyshare *opaque.PrivateKey) (*opaque.ServerLoginState, *opaque.AuthResponse, error) {
+	// dh1 = SerializeElement(server_private_keyshare * ke1.auth_request.client_keyshare)
+	// dh2 = SerializeElement(server_private_key * ke1.auth_request.client_keyshare)
+	// dh3 = SerializeElement(server_private_keyshare * client_public_key)
+	ikm, err := ns.tripleDH(
+		serverPrivateKeyshare, ke1.AuthRequest.ClientKeyshare,
+		serverPrivKey, ke1.AuthRequest.ClientKeyshare,
+		serverPrivateKeyshare, clientPubKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer wipeBytes(ikm)
+
+	authRes := &opaque.AuthResponse{ServerNonce: serverNonce, ServerKeyshare: serverPrivateKeyshare.Public()}
+
+	serverMAC, expectedClientMAC, sessionKey, err := ns.deriveMACs(ikm, clientIdentity, ke1, serverIdentity, credentialRes, authRes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	authRes.ServerMAC = serverMAC
+
+	return &opaque.ServerLoginState{ExpectedClientMac: expectedClientMAC, SessionKey: sessionKey}, authRes, nil
+}
+
+func (ns *nistSuite) AuthServerFinalize(state *opaque.ServerLoginState, ke3 *opaque.KE3) ([]byte, error) {
+	if !hmac.Equal(ke3.ClientMAC, state.ExpectedClientMac) {
+		return nil, opaque.ErrClientAuthentication
+	}
+
+	return state.SessionKey, nil
+}
+
+// tripleDH returns concat(dh1, dh2, dh3) for the three scalar and element pairs given in order.
+func (ns *nistSuite) tripleDH(sk1 *opaque.PrivateKey, pk1 *opaque.PublicKey, sk2 *opaque.PrivateKey, pk2 *opaque.PublicKey, sk3 *opaque.PrivateKey, pk3 *opaque.PublicKey) ([]byte, error) {
+	pairs := []struct {
+		sk *opaque.PrivateKey
+		pk *opaque.PublicKey
+	}{{sk1, pk1}, {sk2, pk2}, {sk3, pk3}}
+
+	var ikm []byte
+
+	for _, pair := range pairs {
+		sc, err := ns.scalar(pair.sk)
+		if err != nil {
+			return nil, err
+		}
+
+		el, err := ns.element(pair.pk)
+		if err != nil {
+			return nil, err
+		}
+
+		ikm = utils.Concat(ikm, ns.group.NewElement().Add(el).Multiply(sc).Encode())
+	}
+
+	return ikm, nil
+}
+
+// deriveMACs runs the key schedule over ikm and the transcript, and returns the server MAC,
+// the client MAC and the session key.
+// Reference: https://www.ietf.org/archive/id/draft-irtf-cfrg-opaque-09.html#name-key-schedule-functions
+func (ns *nistSuite) deriveMACs(ikm, clientIdentity []byte, ke1 *opaque.KE1, serverIdentity []byte, credRes *opaque.CredentialResponse, authRes *opaque.AuthResponse) ([]byte, []byte, []byte, error) {
+	encodedKE1, err := ke1.Serialize()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	encodedCredRes, err := credRes.Serialize()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	encodedKeyshare, err := authRes.ServerKeyshare.MarshalBinary()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if len(clientIdentity) > math.MaxUint16 || len(serverIdentity) > math.MaxUint16 {
+		return nil, nil, nil, opaque.ErrEncodingFailed
+	}
+
+	// preamble = concat("RFCXXXX", I2OSP(len(context), 2), context, I2OSP(len(client_identity), 2), client_identity, ke1,
+	//                   I2OSP(len(server_identity), 2), server_identity, credential_response, server_nonce, server_keyshare)
+	preamble := utils.Concat([]byte(labelNistPreamble),
+		lengthPrefixed(2, []byte(nistContext)),
+		lengthPrefixed(2, clientIdentity),
+		encodedKE1,
+		lengthPrefixed(2, serverIdentity),
+		encodedCredRes,
+		authRes.ServerNonce,
+		encodedKeyshare)
+
+	h := ns.Hash()
+	if err := h.MustWriteAll(preamble); err != nil {
+		return nil, nil, nil, err
+	}
+
+	preambleHash := h.Sum(nil)
+
+	// prk = Extract("", ikm)
+	prk := ns.Extract(nil, ikm)
+	defer wipeBytes(prk)
+
+	// handshake_secret = Derive-Secret(prk, "HandshakeSecret", Hash(preamble))
+	handshakeSecret := ns.deriveSecret(prk, labelNistHandshakeSecret, preambleHash)
+	defer wipeBytes(handshakeSecret)
+
+	// session_key = Derive-Secret(prk, "SessionKey", Hash(preamble))
+	sessionKey := ns.deriveSecret(prk, labelNistSessionKey, preambleHash)
+
+	// Km2 = Derive-Secret(handshake_secret, "ServerMAC", "")
+	km2 := ns.deriveSecret(handshakeSecret, labelNistServerMAC, nil)
+	defer wipeBytes(km2)
+
+	// Km3 = Derive-Secret(handshake_secret, "ClientMAC", "")
+	km3 := ns.deriveSecret(handshakeSecret, labelNistClientMAC, nil)
+	defer wipeBytes(km3)
+
+	// server_mac = MAC(Km2, Hash(preamble))
+	serverMAC, err := ns.MAC(km2, preambleHash)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// client_mac = MAC(Km3, Hash(concat(preamble, server_mac)))
+	h.Reset()
+	if err := h.MustWriteAll(preamble, serverMAC); err != nil {
+		return nil, nil, nil, err
+	}
+
+	clientMAC, err := ns.MAC(km3, h.Sum(nil))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return serverMAC, clientMAC, sessionKey, nil
+}
+
+// deriveSecret is Derive-Secret(secret, label, context) = Expand(secret, CustomLabel, Nx), with
+//
+//	CustomLabel = concat(I2OSP(Nx, 2), I2OSP(len("OPAQUE-" + label), 1), "OPAQUE-" + label, I2OSP(len(context), 1), context)
+func (ns *nistSuite) deriveSecret(secret []byte, label string, context []byte) []byte {
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(ns.Nx()))
+
+	customLabel := utils.Concat(length,
+		lengthPrefixed(1, []byte(labelNistLabelPrefix+label)),
+		lengthPrefixed(1, context))
+
+	return ns.Expand(secret, customLabel, ns.Nx())
+}
+
+// lengthPrefixed returns data preceded by its length in size bytes. The length must fit.
+func lengthPrefixed(size int, data []byte) []byte {
+	prefix := make([]byte, 8)
+	binary.BigEndian.PutUint64(prefix, uint64(len(data)))
+
+	return utils.Concat(prefix[8-size:], data)
+}
+
+// xorBytes returns a xor b, which must have the same length.
+func xorBytes(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+
+	return out
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/cymony/cryptomony/eccgroup"
+	"github.com/cymony/cryptomony/hash"
+	"github.com/cymony/cryptomony/ksf"
+	"github.com/cymony/cryptomony/opaque"
+	"github.com/cymony/cryptomony/oprf"
+)
+
+// TestSuitesInterop registers and logs in a client against a server for every supported suite.
+func TestSuitesInterop(t *testing.T) {
+	for _, entry := range supportedSuites {
+		name := string(entry.name)
+
+		c := newClient()
+		if err := c.InitializeClient(name, "example.com"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		s := newServer()
+		if err := s.InitializeServer(name, "example.com", nil, nil, true); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		regState, regReq, err := c.RegistrationInit("password")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		regRes, err := s.RegistrationEval(regReq, nil, "alice")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		record, regExportKey, err := c.RegistrationFinalize(regState, regRes, "alice")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if err := s.RegisterUpload("alice", record); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		loginState, ke1, err := c.LoginInit("password")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		svLoginState, ke2, err := s.LoginInitByCredID("alice", ke1, nil, "", "", "alice")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		ke3, clSessionKey, exportKey, err := c.LoginFinish(loginState, ke2, "alice")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		svSessionKey, err := s.LoginFinish(svLoginState, ke3)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		suite := newSuite(entry.id)
+
+		if len(clSessionKey) != suite.Nx() || !bytes.Equal(clSessionKey, svSessionKey) {
+			t.Errorf("%s: client and server session keys differ", name)
+		}
+
+		if len(exportKey) != suite.Nh() || !bytes.Equal(exportKey, regExportKey) {
+			t.Errorf("%s: login export key differs from the registration one", name)
+		}
+
+		loginState, ke1, err = c.LoginInit("wrong password")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if _, ke2, err = s.LoginInitByCredID("alice", ke1, nil, "", "", "alice"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		var apiErr *apiError
+		if _, _, _, err := c.LoginFinish(loginState, ke2, "alice"); !errors.As(err, &apiErr) || apiErr.code != codeAuthFailed {
+			t.Errorf("%s: login with a wrong password: got %v, want an authentication failure", name, err)
+		}
+	}
+}
+
+// newP256NistSuite builds nistSuite over P-256, where it must behave like the cryptomony suite.
+func newP256NistSuite() opaque.Suite {
+	return &nistSuite{oprf: oprf.SuiteP256Sha256, group: eccgroup.P256Sha256, hsh: hash.SHA256, ksf: ksf.Scrypt}
+}
+
+// TestNistSuiteMatchesCryptomony runs nistSuite over P-256 against the cryptomony P-256 suite,
+// with fixed inputs where the output must be identical and with each on either side of a login.
+func TestNistSuiteMatchesCryptomony(t *testing.T) {
+	ns, cs := newP256NistSuite(), opaque.P256Suite.New()
+
+	seed := bytes.Repeat([]byte{0x07}, 32)
+	oprfSeed := bytes.Repeat([]byte{0x2a}, cs.Nh())
+	nonce := bytes.Repeat([]byte{0x5c}, 32)
+	password, credID := []byte("password"), []byte("alice")
+
+	serverPrivKey, err := cs.DeriveKeyPair(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, derive := range []func(opaque.Suite) (*opaque.PrivateKey, error){
+		func(s opaque.Suite) (*opaque.PrivateKey, error) { return s.DeriveKeyPair(seed) },
+		func(s opaque.Suite) (*opaque.PrivateKey, error) { return s.DeriveAuthKeyPair(seed) },
+	} {
+		want, err := derive(cs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := derive(ns)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkSameEncoding(t, "derived key", got, want)
+	}
+
+	// Registration with the cryptomony client and the nistSuite server.
+	regReq, blind, err := cs.CreateRegistrationRequest(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	regRes, err := ns.CreateRegistrationResponse(regReq, serverPrivKey.Public(), credID, oprfSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantRegRes, err := cs.CreateRegistrationResponse(regReq, serverPrivKey.Public(), credID, oprfSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkSameEncoding(t, "registration response", regRes, wantRegRes)
+
+	record, regExportKey, err := cs.FinalizeRegistrationRequest(password, nil, credID, blind, regRes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server side of a login with fixed nonces and keyshare.
+	clState, ke1, err := ns.ClientInit(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credRes, err := ns.CreateCredentialResponse(ke1.CredentialRequest, serverPrivKey.Public(), record, credID, oprfSeed, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantCredRes, err := cs.CreateCredentialResponse(ke1.CredentialRequest, serverPrivKey.Public(), record, credID, oprfSeed, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkSameEncoding(t, "credential response", credRes, wantCredRes)
+
+	keyshare, err := cs.DeriveAuthKeyPair(bytes.Repeat([]byte{0x11}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svState, authRes, err := ns.AuthServerRespond(serverPrivKey, []byte("example.com"), credID, nonce, record.ClientPubKey, ke1, credRes, keyshare)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantSvState, wantAuthRes, err := cs.AuthServerRespond(serverPrivKey, []byte("example.com"), credID, nonce, record.ClientPubKey, ke1, credRes, keyshare)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkSameEncoding(t, "auth response", authRes, wantAuthRes)
+	checkSameEncoding(t, "server login state", svState, wantSvState)
+
+	// A login with the nistSuite client and the cryptomony server, then the other way round.
+	for _, sides := range []struct{ client, server opaque.Suite }{{ns, cs}, {cs, ns}} {
+		if sides.client != ns {
+			if clState, ke1, err = sides.client.ClientInit(password); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		svState, ke2, err := sides.server.ServerInit(serverPrivKey, serverPrivKey.Public(), record, ke1, credID, credID, nil, oprfSeed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ke3, sessionKey, exportKey, err := sides.client.ClientFinish(clState, credID, nil, ke2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		svSessionKey, err := sides.server.ServerFinish(svState, ke3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(sessionKey, svSessionKey) || !bytes.Equal(exportKey, regExportKey) {
+			t.Error("keys differ between the nistSuite and cryptomony sides")
+		}
+	}
+}
+
+type serializer interface {
+	Serialize() ([]byte, error)
+}
+
+type binaryMarshaler interface {
+	MarshalBinary() ([]byte, error)
+}
+
+func checkSameEncoding(t *testing.T, name string, got, want any) {
+	t.Helper()
+
+	encode := func(v any) []byte {
+		var (
+			b   []byte
+			err error
+		)
+
+		switch v := v.(type) {
+		case serializer:
+			b, err = v.Serialize()
+		case binaryMarshaler:
+			b, err = v.MarshalBinary()
+		default:
+			t.Fatalf("%s: can not encode %T", name, v)
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		return b
+	}
+
+	if !bytes.Equal(encode(got), encode(want)) {
+		t.Errorf("%s differs from the cryptomony one", name)
+	}
+}
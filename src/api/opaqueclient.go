@@ -0,0 +1,45 @@
+package main
+
+import "github.com/cymony/cryptomony/opaque"
+
+// opaqueClient is opaque.Client over the suite built by newSuite, since opaque.NewClient only
+// builds the suites of cryptomony.
+type opaqueClient struct {
+	suite          opaque.Suite
+	serverIdentity []byte
+}
+
+func newOpaqueClient(conf *opaque.ClientConfiguration) opaque.Client {
+	return &opaqueClient{suite: newSuite(conf.OpaqueSuite), serverIdentity: conf.ServerID}
+}
+
+func (oc *opaqueClient) CreateRegistrationRequest(password []byte) (*opaque.ClientRegistrationState, *opaque.RegistrationRequest, error) {
+	regReq, blind, err := oc.suite.CreateRegistrationRequest(password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &opaque.ClientRegistrationState{Blind: blind, Password: password}, regReq, nil
+}
+
+func (oc *opaqueClient) FinalizeRegistrationRequest(clRegState *opaque.ClientRegistrationState, clientIdentity, regRes []byte) (*opaque.RegistrationRecord, []byte, error) {
+	decodedRegRes := &opaque.RegistrationResponse{}
+	if err := decodedRegRes.Decode(oc.suite, regRes); err != nil {
+		return nil, nil, err
+	}
+
+	return oc.suite.FinalizeRegistrationRequest(clRegState.Password, oc.serverIdentity, clientIdentity, clRegState.Blind, decodedRegRes)
+}
+
+func (oc *opaqueClient) ClientInit(password []byte) (*opaque.ClientLoginState, *opaque.KE1, error) {
+	return oc.suite.ClientInit(password)
+}
+
+func (oc *opaqueClient) ClientFinish(clLoginState *opaque.ClientLoginState, clientIdentity, ke2 []byte) (*opaque.KE3, []byte, []byte, error) {
+	decodedKE2 := &opaque.KE2{}
+	if err := decodedKE2.Decode(oc.suite, ke2); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return oc.suite.ClientFinish(clLoginState, clientIdentity, oc.serverIdentity, decodedKE2)
+}
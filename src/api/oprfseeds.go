@@ -0,0 +1,177 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// maxKeyIDLen bounds the length of OPRF seed and server key identifiers.
+const maxKeyIDLen = 255
+
+var (
+	errNoActiveOprfSeed = newError(codeNotInitialized, "an active oprf seed must be set first")
+	errOprfSeedNotFound = &apiError{code: codeNotFound, argName: "keyID", msg: "no oprf seed with this key ID"}
+)
+
+// oprfSeedSet keeps the OPRF seeds of a server by key ID. New registrations use the active
+// seed and logins select the seed the record was registered with by its key ID, so seeds can be
+// rotated without invalidating existing records. It is safe for concurrent use.
+//
+// get and current return the stored seeds themselves. remove, replace and clear wipe them,
+// so the server only calls those with its write lock held, while the callers of get and
+// current hold the read lock for as long as they use the seed.
+type oprfSeedSet struct {
+	mu     sync.RWMutex
+	seeds  map[string][]byte
+	active string // empty when no seed is active
+}
+
+func checkKeyID(keyID string) error {
+	if len(keyID) == 0 || len(keyID) > maxKeyIDLen {
+		return argError("keyID", "key ID must be between 1 and %d bytes", maxKeyIDLen)
+	}
+	return nil
+}
+
+// add stores seed under keyID. The first seed added becomes the active one.
+func (ss *oprfSeedSet) add(keyID string, seed []byte) error {
+	if err := checkKeyID(keyID); err != nil {
+		return err
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if _, ok := ss.seeds[keyID]; ok {
+		return argError("keyID", "an oprf seed with key ID %q already exists", keyID)
+	}
+
+	if ss.seeds == nil {
+		ss.seeds = make(map[string][]byte)
+	}
+
+	ss.seeds[keyID] = seed
+	if ss.active == "" {
+		ss.active = keyID
+	}
+
+	return nil
+}
+
+// setActive makes the seed of keyID the one used by new registrations.
+func (ss *oprfSeedSet) setActive(keyID string) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if _, ok := ss.seeds[keyID]; !ok {
+		return errOprfSeedNotFound
+	}
+
+	ss.active = keyID
+	return nil
+}
+
+// remove wipes and forgets the seed of keyID. The active seed can not be removed.
+func (ss *oprfSeedSet) remove(keyID string) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	seed, ok := ss.seeds[keyID]
+	if !ok {
+		return errOprfSeedNotFound
+	}
+
+	if keyID == ss.active {
+		return argError("keyID", "the active oprf seed can not be removed")
+	}
+
+	wipeBytes(seed)
+	delete(ss.seeds, keyID)
+	return nil
+}
+
+// get returns the seed of keyID.
+func (ss *oprfSeedSet) get(keyID string) ([]byte, error) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	seed, ok := ss.seeds[keyID]
+	if !ok {
+		return nil, errOprfSeedNotFound
+	}
+
+	return seed, nil
+}
+
+// current returns the key ID and the seed of the active seed.
+func (ss *oprfSeedSet) current() (string, []byte, error) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	if ss.active == "" {
+		return "", nil, errNoActiveOprfSeed
+	}
+
+	return ss.active, ss.seeds[ss.active], nil
+}
+
+// list returns the sorted key IDs and the active key ID.
+func (ss *oprfSeedSet) list() ([]string, string) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	keyIDs := make([]string, 0, len(ss.seeds))
+	for keyID := range ss.seeds {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+
+	return keyIDs, ss.active
+}
+
+// snapshot returns copies of the seeds sorted by key ID, and the active key ID.
+func (ss *oprfSeedSet) snapshot() ([]setupSeed, string) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	seeds := make([]setupSeed, 0, len(ss.seeds))
+	for keyID, seed := range ss.seeds {
+		seeds = append(seeds, setupSeed{id: keyID, seed: append([]byte(nil), seed...)})
+	}
+	sort.Slice(seeds, func(i, j int) bool { return seeds[i].id < seeds[j].id })
+
+	return seeds, ss.active
+}
+
+// replace wipes every seed and stores seeds instead, with active as the active one.
+// The set takes ownership of the seeds.
+func (ss *oprfSeedSet) replace(seeds []setupSeed, active string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	for keyID, seed := range ss.seeds {
+		wipeBytes(seed)
+		delete(ss.seeds, keyID)
+	}
+
+	if ss.seeds == nil {
+		ss.seeds = make(map[string][]byte, len(seeds))
+	}
+
+	for _, seed := range seeds {
+		ss.seeds[seed.id] = seed.seed
+	}
+	ss.active = active
+}
+
+// clear wipes and forgets every seed.
+func (ss *oprfSeedSet) clear() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	for keyID, seed := range ss.seeds {
+		wipeBytes(seed)
+		delete(ss.seeds, keyID)
+	}
+	ss.active = ""
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestOprfSeedRotationRecordStore registers a record in the record store under one seed, rotates
+// to another and checks that the record still logs in and changes its password through its key ID.
+func TestOprfSeedRotationRecordStore(t *testing.T) {
+	sv := newServer()
+	if err := sv.InitializeServer("Ristretto255Suite", "example.com", nil, nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sv.AddOprfSeed("old", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cl := newClient()
+	if err := cl.InitializeClient("Ristretto255Suite", "example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	regState, regReq, err := cl.RegistrationInit("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	regRes, keyID, _, err := sv.RegistrationEvalKeyed(regReq, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, exportKey, err := cl.RegistrationFinalize(regState, regRes, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sv.RegisterUpload("alice", record); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sv.AddOprfSeed("new", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sv.SetActiveOprfSeed("new"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		keyID    string
+		wantCode errorCode // empty when the client must finish the login
+	}{
+		{name: "seed of the record", keyID: keyID},
+		{name: "active seed", keyID: "", wantCode: codeAuthFailed},
+		{name: "unknown seed", keyID: "missing", wantCode: codeNotFound},
+	}
+
+	for _, tt := range tests {
+		loginState, ke1, err := cl.LoginInit("password")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, ke2, err := sv.LoginInitByCredID("alice", ke1, nil, tt.keyID, "", "alice")
+		if err == nil {
+			_, _, gotExportKey, finishErr := cl.LoginFinish(loginState, ke2, "alice")
+			if finishErr == nil && !bytes.Equal(gotExportKey, exportKey) {
+				t.Errorf("%s: export key differs from the registration one", tt.name)
+			}
+			err = finishErr
+		}
+
+		if code := errorCodeOf(err); code != tt.wantCode {
+			t.Errorf("%s: got %v, want code %q", tt.name, err, tt.wantCode)
+		}
+	}
+
+	if _, _, err := sv.LoginInitByCredID("alice", nil, []byte("seed"), keyID, "", "alice"); err == nil {
+		t.Error("an oprf seed given along with a key ID was accepted")
+	}
+
+	handle, ke1, newRegReq, err := cl.ChangePasswordInit("password", "new password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, ke2, newRegRes, newKeyID, err := sv.ChangePasswordInit("alice", ke1, newRegReq, nil, keyID, "", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if newKeyID != "new" {
+		t.Fatalf("new record registered with seed %q, want the active seed", newKeyID)
+	}
+
+	change, err := cl.ChangePasswordFinish(handle, ke2, newRegRes, "alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sv.ChangePasswordFinish(session, change.ke3, change.record); err != nil {
+		t.Fatal(err)
+	}
+
+	loginState, ke1, err := cl.LoginInit("new password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ke2, err = sv.LoginInitByCredID("alice", ke1, nil, newKeyID, "", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := cl.LoginFinish(loginState, ke2, "alice"); err != nil {
+		t.Fatalf("login with the new password: %v", err)
+	}
+}
+
+// errorCodeOf returns the code err is reported with across the JS boundary, or an empty code for nil.
+func errorCodeOf(err error) errorCode {
+	if err == nil {
+		return ""
+	}
+
+	code, _ := classifyError(err)
+	return code
+}
+
+// TestSetupRoundTripSeedSet exports and imports a server that only uses its seed set and checks
+// that the imported server still follows setActiveOprfSeed instead of a seed bound by the import.
+func TestSetupRoundTripSeedSet(t *testing.T) {
+	sv := newServer()
+	if err := sv.InitializeServer("Ristretto255Suite", "example.com", nil, nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, keyID := range []string{"a", "b"} {
+		if _, err := sv.AddOprfSeed(keyID, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	blob, err := sv.ExportSetup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported := newServer()
+
+	seed, err := imported.ImportSetup(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if seed != nil {
+		t.Fatal("a seed was bound by importing a server that had none")
+	}
+
+	if err := imported.SetActiveOprfSeed("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	wantSeed, err := imported.seeds.get("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotSeed, err := imported.oprfSeedFor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(gotSeed, wantSeed) {
+		t.Error("the imported server does not use the active seed")
+	}
+}
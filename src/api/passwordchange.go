@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/cymony/cryptomony/opaque"
+)
+
+// A password change runs a login with the old password and a registration of the new one
+// in the same round trips. The pending states are kept in the session store of the instance:
+//
+//	client: concat(loginState<0..2^16-1>, registrationState<0..2^16-1>)
+//	server: concat(credentialID<0..2^16-1>, loginState<0..2^16-1>)
+//
+// The server keeps the credential identifier so that the record replaced is the one authenticated.
+
+var errInvalidChangeState = newError(codeDecode, "invalid password change state")
+
+// passwordChange is the result of a password change on the client.
+type passwordChange struct {
+	ke3          []byte
+	record       []byte // registration record of the new password
+	sessionKey   []byte
+	oldExportKey []byte
+	newExportKey []byte
+	vaultBlobs   [][]byte // sealed with the new export key
+}
+
+// encodeChangeState concatenates the given vectors. The caller keeps ownership of first and second.
+func encodeChangeState(first, second []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	if err := writeVector(buf, first); err != nil {
+		return nil, err
+	}
+
+	if err := writeVector(buf, second); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decodeChangeState(state []byte) ([]byte, []byte, error) {
+	r := bytes.NewReader(state)
+
+	first, err := readVector(r)
+	if err != nil {
+		return nil, nil, errInvalidChangeState
+	}
+
+	second, err := readVector(r)
+	if err != nil || r.Len() != 0 {
+		return nil, nil, errInvalidChangeState
+	}
+
+	return first, second, nil
+}
+
+// resealVaults opens every vault blob with the old export key and seals it again with the new one.
+// It fails on the first blob that does not open, naming its index.
+func resealVaults(suiteID opaque.Identifier, oldExportKey, newExportKey []byte, clientIdentity string, blobs [][]byte) ([][]byte, error) {
+	resealed := make([][]byte, len(blobs))
+
+	for i, blob := range blobs {
+		plaintext, err := openVault(oldExportKey, clientIdentity, blob)
+		if err != nil {
+			return nil, fmt.Errorf("vault blob %d: %w", i, err)
+		}
+
+		resealed[i], err = sealVault(suiteID, newExportKey, clientIdentity, plaintext)
+		wipeBytes(plaintext)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resealed, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestPasswordChange changes the password of a record in the record store and checks which
+// password logs in afterwards and that the vaults are resealed for the new export key.
+func TestPasswordChange(t *testing.T) {
+	tests := []struct {
+		name          string
+		oldPassword   string
+		vaultIdentity string
+		tamperKE3     bool
+		wantClient    errorCode
+		wantServer    errorCode
+		wantPassword  string // the password that logs in after the change
+	}{
+		{name: "changed", oldPassword: "password", vaultIdentity: "alice", wantPassword: "new password"},
+		{name: "wrong old password", oldPassword: "wrong", vaultIdentity: "alice", wantClient: codeAuthFailed, wantPassword: "password"},
+		{name: "vault of another identity", oldPassword: "password", vaultIdentity: "bob", wantClient: codeAuthFailed, wantPassword: "password"},
+		{name: "tampered KE3", oldPassword: "password", vaultIdentity: "alice", tamperKE3: true, wantServer: codeAuthFailed, wantPassword: "password"},
+	}
+
+	for _, tt := range tests {
+		sv, cl, record := newTestLogin(t)
+		if err := sv.RegisterUpload("alice", record); err != nil {
+			t.Fatal(err)
+		}
+
+		oldExportKey, err := loginByCredID(t, sv, cl, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		suiteID := cl.cConf.OpaqueSuite
+
+		vault, err := sealVault(suiteID, oldExportKey, tt.vaultIdentity, []byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clHandle, ke1, regReq, err := cl.ChangePasswordInit(tt.oldPassword, "new password")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		svHandle, ke2, regRes, _, err := sv.ChangePasswordInit("alice", ke1, regReq, nil, "", "", "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		change, err := cl.ChangePasswordFinish(clHandle, ke2, regRes, "alice", [][]byte{vault})
+		if errorCodeOf(err) != tt.wantClient {
+			t.Errorf("%s: client: got %v, want code %q", tt.name, err, tt.wantClient)
+		}
+
+		if err == nil {
+			if tt.tamperKE3 {
+				change.ke3[len(change.ke3)-1] ^= 1
+			}
+
+			if _, err := sv.ChangePasswordFinish(svHandle, change.ke3, change.record); errorCodeOf(err) != tt.wantServer {
+				t.Errorf("%s: server: got %v, want code %q", tt.name, err, tt.wantServer)
+			}
+
+			if got, err := openVault(change.newExportKey, "alice", change.vaultBlobs[0]); err != nil || !bytes.Equal(got, []byte("secret")) {
+				t.Errorf("%s: resealed vault: got %q, %v", tt.name, got, err)
+			}
+
+			if _, err := openVault(oldExportKey, "alice", change.vaultBlobs[0]); errorCodeOf(err) != codeAuthFailed {
+				t.Errorf("%s: resealed vault opened with the old export key: %v", tt.name, err)
+			}
+		}
+
+		for _, password := range []string{"password", "new password"} {
+			_, err := loginByCredID(t, sv, cl, password)
+			if ok := err == nil; ok != (password == tt.wantPassword) {
+				t.Errorf("%s: login with %q: got %v", tt.name, password, err)
+			}
+		}
+	}
+}
+
+// loginByCredID logs "alice" in with the record of the record store and returns the export key of the client.
+func loginByCredID(t *testing.T, sv *server, cl *client, password string) ([]byte, error) {
+	t.Helper()
+
+	loginState, ke1, err := cl.LoginInit(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svState, ke2, err := sv.LoginInitByCredID("alice", ke1, nil, "", "", "alice")
+	if err != nil {
+		return nil, err
+	}
+
+	ke3, _, exportKey, err := cl.LoginFinish(loginState, ke2, "alice")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := sv.LoginFinish(svState, ke3); err != nil {
+		return nil, err
+	}
+
+	return exportKey, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"container/heap"
+	"crypto/sha256"
+	"time"
+)
+
+// maxPendingLogins bounds the number of pending logins tracked by one server.
+const maxPendingLogins = 1 << 16
+
+type pendingKey [sha256.Size]byte
+
+type pendingLogin[V any] struct {
+	key      pendingKey
+	deadline time.Time
+	value    V
+}
+
+// pendingQueue orders pending logins by deadline, soonest first. It may hold logins already taken.
+type pendingQueue[V any] []*pendingLogin[V]
+
+func (q pendingQueue[V]) Len() int           { return len(q) }
+func (q pendingQueue[V]) Less(i, j int) bool { return q[i].deadline.Before(q[j].deadline) }
+func (q pendingQueue[V]) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *pendingQueue[V]) Push(x any)        { *q = append(*q, x.(*pendingLogin[V])) }
+
+func (q *pendingQueue[V]) Pop() any {
+	old := *q
+	last := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return last
+}
+
+// pendingLogins maps the logins handed out by a server, keyed by the hash of their expected
+// client MAC, to their deadline and a value. When it holds maxPendingLogins logins the one closest
+// to its deadline is dropped, so a flood of logins that are never finished can not refuse new ones.
+// It is not safe for concurrent use.
+type pendingLogins[V any] struct {
+	logins map[pendingKey]*pendingLogin[V]
+	queue  pendingQueue[V]
+}
+
+// add records the login with the given expected client MAC and value until deadline.
+// The logins expired at now or dropped to make room are passed to dropped, when not nil,
+// along with their deadline or now respectively.
+func (pl *pendingLogins[V]) add(expectedClientMac []byte, value V, deadline, now time.Time, dropped func(value V, at time.Time)) {
+	if pl.logins == nil {
+		pl.logins = make(map[pendingKey]*pendingLogin[V])
+	}
+
+	pl.expire(now, dropped)
+
+	for len(pl.logins) >= maxPendingLogins {
+		if login := pl.pop(); login != nil && dropped != nil {
+			dropped(login.value, now)
+		}
+	}
+
+	// Drop the logins taken since, once they make up most of the queue.
+	if len(pl.queue) > 2*len(pl.logins)+64 {
+		live := pl.queue[:0]
+		for _, login := range pl.queue {
+			if pl.isLive(login) {
+				live = append(live, login)
+			}
+		}
+		for i := len(live); i < len(pl.queue); i++ {
+			pl.queue[i] = nil
+		}
+		pl.queue = live
+		heap.Init(&pl.queue)
+	}
+
+	login := &pendingLogin[V]{key: sha256.Sum256(expectedClientMac), deadline: deadline, value: value}
+	pl.logins[login.key] = login
+	heap.Push(&pl.queue, login)
+}
+
+// expire forgets the logins expired at now and passes them to dropped, when not nil,
+// along with their deadline.
+func (pl *pendingLogins[V]) expire(now time.Time, dropped func(value V, at time.Time)) {
+	for len(pl.queue) > 0 && !now.Before(pl.queue[0].deadline) {
+		if login := pl.pop(); login != nil && dropped != nil {
+			dropped(login.value, login.deadline)
+		}
+	}
+}
+
+// take forgets the login with the given expected client MAC and returns its value and deadline.
+// ok is false when the login is not tracked.
+func (pl *pendingLogins[V]) take(expectedClientMac []byte) (value V, deadline time.Time, ok bool) {
+	key := pendingKey(sha256.Sum256(expectedClientMac))
+
+	login, ok := pl.logins[key]
+	if !ok {
+		return value, deadline, false
+	}
+
+	delete(pl.logins, key)
+	return login.value, login.deadline, true
+}
+
+// count returns the number of logins tracked, expired ones not yet dropped included.
+func (pl *pendingLogins[V]) count() int {
+	return len(pl.logins)
+}
+
+// clear forgets every login.
+func (pl *pendingLogins[V]) clear() {
+	pl.logins = nil
+	pl.queue = nil
+}
+
+// pop removes the front of the queue and forgets it. It returns nil when the login was already taken.
+func (pl *pendingLogins[V]) pop() *pendingLogin[V] {
+	login := heap.Pop(&pl.queue).(*pendingLogin[V])
+	if !pl.isLive(login) {
+		return nil
+	}
+
+	delete(pl.logins, login.key)
+	return login
+}
+
+func (pl *pendingLogins[V]) isLive(login *pendingLogin[V]) bool {
+	return pl.logins[login.key] == login
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"sync"
+)
+
+// recordStore keeps registration records by credential identifier.
+type recordStore interface {
+	// Get returns the record of credID. found is false when there is none.
+	Get(credID string) (record []byte, found bool, err error)
+	Put(credID string, record []byte) error
+	Delete(credID string) error
+}
+
+// memoryRecordStore is a recordStore kept in memory. It is safe for concurrent use.
+type memoryRecordStore struct {
+	mu      sync.RWMutex
+	records map[string][]byte
+}
+
+func newMemoryRecordStore() *memoryRecordStore {
+	return &memoryRecordStore{records: make(map[string][]byte)}
+}
+
+func (ms *memoryRecordStore) Get(credID string) ([]byte, bool, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	record, ok := ms.records[credID]
+	if !ok {
+		return nil, false, nil
+	}
+
+	return append([]byte(nil), record...), true, nil
+}
+
+func (ms *memoryRecordStore) Put(credID string, record []byte) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	wipeBytes(ms.records[credID])
+	ms.records[credID] = append([]byte(nil), record...)
+	return nil
+}
+
+func (ms *memoryRecordStore) Delete(credID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	wipeBytes(ms.records[credID])
+	delete(ms.records, credID)
+	return nil
+}
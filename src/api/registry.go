@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// instance is implemented by every type kept in a registry.
+type instance interface {
+	destroy()
+}
+
+// tombstones remembers the identifiers of the most recently destroyed instances, so that lookups
+// can tell a destroyed instance from an unknown one. It keeps at most maxTombstones identifiers
+// and forgets the oldest first. It is guarded by the registry lock.
+type tombstones struct {
+	ids   map[string]struct{}
+	order []string // oldest first
+}
+
+func (t *tombstones) has(id string) bool {
+	_, ok := t.ids[id]
+	return ok
+}
+
+func (t *tombstones) add(id string) {
+	if t.ids == nil {
+		t.ids = make(map[string]struct{})
+	}
+
+	if t.has(id) {
+		return
+	}
+
+	if len(t.order) >= maxTombstones {
+		delete(t.ids, t.order[0])
+		t.order = t.order[1:]
+	}
+
+	t.ids[id] = struct{}{}
+	t.order = append(t.order, id)
+}
+
+func (t *tombstones) remove(id string) {
+	if !t.has(id) {
+		return
+	}
+
+	delete(t.ids, id)
+	for i, old := range t.order {
+		if old == id {
+			t.order = append(t.order[:i], t.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// registry keeps track of the instances of one kind by their identifiers.
+// It is safe for concurrent use.
+type registry[T instance] struct {
+	mu           sync.RWMutex
+	kind         string
+	newInstance  func() T
+	instances    map[string]T
+	destroyed    tombstones
+	maxInstances int // 0 means unlimited
+}
+
+func newRegistry[T instance](kind string, newInstance func() T) *registry[T] {
+	return &registry[T]{
+		kind:        kind,
+		newInstance: newInstance,
+		instances:   make(map[string]T),
+	}
+}
+
+// create stores a new empty instance and returns its identifier.
+func (r *registry[T]) create() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxInstances > 0 && len(r.instances) >= r.maxInstances {
+		return "", errMaxInstances
+	}
+
+	id, err := generateID(func(id string) bool {
+		_, ok := r.instances[id]
+		return ok || r.destroyed.has(id)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	r.instances[id] = r.newInstance()
+	return id, nil
+}
+
+// add stores inst under an identifier chosen by the caller, such as a tenant name.
+// Unlike generated identifiers, the identifier of a destroyed instance can be used again.
+func (r *registry[T]) add(id string, inst T, argName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.instances[id]; ok {
+		return argError(argName, "%s %q already exists", r.kind, id)
+	}
+
+	if r.maxInstances > 0 && len(r.instances) >= r.maxInstances {
+		return errMaxInstances
+	}
+
+	r.destroyed.remove(id)
+	r.instances[id] = inst
+	return nil
+}
+
+// get returns the instance with the given identifier.
+func (r *registry[T]) get(id string) (T, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	inst, ok := r.instances[id]
+	if !ok {
+		if r.destroyed.has(id) {
+			return inst, errInstanceDestroyed
+		}
+		return inst, newError(codeNotFound, fmt.Sprintf("%s not found", r.kind))
+	}
+
+	return inst, nil
+}
+
+// destroy removes the instance and wipes its secrets. Later lookups of the identifier
+// fail with errInstanceDestroyed, until maxTombstones later destroys have happened.
+func (r *registry[T]) destroy(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	inst, ok := r.instances[id]
+	if !ok {
+		if r.destroyed.has(id) {
+			return errInstanceDestroyed
+		}
+		return newError(codeNotFound, fmt.Sprintf("%s not found", r.kind))
+	}
+
+	inst.destroy()
+	delete(r.instances, id)
+	r.destroyed.add(id)
+
+	return nil
+}
+
+// destroyAll removes every instance and wipes their secrets.
+func (r *registry[T]) destroyAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, inst := range r.instances {
+		inst.destroy()
+		delete(r.instances, id)
+		r.destroyed.add(id)
+	}
+}
+
+// list returns the identifiers of the live instances in sorted order.
+func (r *registry[T]) list() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := make([]string, 0, len(r.instances))
+	for id := range r.instances {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+// setMaxInstances limits the number of live instances. Zero removes the limit.
+func (r *registry[T]) setMaxInstances(n int) error {
+	if n < 0 {
+		return argError("max", "maximum number of instances must not be negative")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.maxInstances = n
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cymony/cryptomony/opaque"
+	"github.com/cymony/cryptomony/utils"
+)
+
+// Sealed state blob layout:
+//
+//	magic[4] = "COST"
+//	version[1]
+//	kind[1]
+//	suite[2]
+//	expires[8]
+//	nonce[12]
+//	ciphertext
+//
+// The header up to the nonce and the binding of the instance are authenticated
+// as additional data. All integers are big-endian, expires is in unix seconds.
+const (
+	sealedStateMagic   = "COST"
+	sealedStateVersion = 1
+	sealedHeaderLen    = len(sealedStateMagic) + 1 + 1 + 2 + 8
+	sealingKeyLen      = 32
+)
+
+// stateKind tells the sealed states apart so that one can not be passed for another.
+type stateKind byte
+
+const (
+	stateClientRegistration stateKind = iota + 1
+	stateClientLogin
+	stateServerLogin
+)
+
+var errSealedState = newError(codeDecode, "sealed state is invalid or was modified")
+
+// stateSealer seals the states exported by an instance with an AEAD key held by the instance.
+// While no key is set states are exported as they are. It is safe for concurrent use.
+type stateSealer struct {
+	mu   sync.RWMutex
+	key  []byte
+	aead cipher.AEAD
+}
+
+// setKey enables sealing with the given key, or with a new random key when key is empty.
+// It returns the key in use.
+func (ss *stateSealer) setKey(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		key = utils.RandomBytes(sealingKeyLen)
+	}
+
+	if len(key) != sealingKeyLen {
+		return nil, argError("key", "sealing key must be %d bytes", sealingKeyLen)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	wipeBytes(ss.key)
+	ss.key = key
+	ss.aead = aead
+
+	return key, nil
+}
+
+// disable wipes the key and turns sealing off.
+func (ss *stateSealer) disable() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	wipeBytes(ss.key)
+	ss.key = nil
+	ss.aead = nil
+}
+
+// enabled reports whether states are sealed.
+func (ss *stateSealer) enabled() bool {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	return ss.aead != nil
+}
+
+// seal encrypts state into a sealed state blob expiring at expires.
+// The plain state is wiped. state is returned as it is when sealing is off.
+func (ss *stateSealer) seal(kind stateKind, suiteID opaque.Identifier, binding, state []byte, expires time.Time) ([]byte, error) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	if ss.aead == nil {
+		return state, nil
+	}
+	defer wipeBytes(state)
+
+	header := &bytes.Buffer{}
+	header.WriteString(sealedStateMagic)
+	header.WriteByte(sealedStateVersion)
+	header.WriteByte(byte(kind))
+
+	if err := binary.Write(header, binary.BigEndian, uint16(suiteID)); err != nil {
+		return nil, err
+	}
+
+	if err := binary.Write(header, binary.BigEndian, uint64(expires.Unix())); err != nil {
+		return nil, err
+	}
+
+	nonce := utils.RandomBytes(ss.aead.NonceSize())
+	ad := utils.Concat(header.Bytes(), binding)
+
+	return utils.Concat(header.Bytes(), nonce, ss.aead.Seal(nil, nonce, state, ad)), nil
+}
+
+// open checks and decrypts a sealed state blob created by seal with the same kind, suite and binding.
+// A copy of blob is returned when sealing is off. The caller should wipe the returned state.
+func (ss *stateSealer) open(kind stateKind, suiteID opaque.Identifier, binding, blob []byte, argName string, now time.Time) ([]byte, error) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	if ss.aead == nil {
+		return append([]byte(nil), blob...), nil
+	}
+
+	nonceLen := ss.aead.NonceSize()
+	if len(blob) < sealedHeaderLen+nonceLen+ss.aead.Overhead() {
+		return nil, decodeError(argName, errSealedState)
+	}
+
+	header := blob[:sealedHeaderLen]
+	if string(header[:len(sealedStateMagic)]) != sealedStateMagic ||
+		header[4] != sealedStateVersion ||
+		stateKind(header[5]) != kind ||
+		opaque.Identifier(binary.BigEndian.Uint16(header[6:8])) != suiteID {
+		return nil, decodeError(argName, errSealedState)
+	}
+
+	nonce := blob[sealedHeaderLen : sealedHeaderLen+nonceLen]
+	ad := utils.Concat(header, binding)
+
+	state, err := ss.aead.Open(nil, nonce, blob[sealedHeaderLen+nonceLen:], ad)
+	if err != nil {
+		return nil, decodeError(argName, errSealedState)
+	}
+
+	// The expiry is only trusted once it is authenticated.
+	expires := int64(binary.BigEndian.Uint64(header[8:sealedHeaderLen]))
+	if now.Unix() >= expires {
+		wipeBytes(state)
+		return nil, &apiError{code: codeExpired, argName: argName, msg: fmt.Sprintf("%s has expired", argName)}
+	}
+
+	return state, nil
+}
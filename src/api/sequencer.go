@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// phase is the protocol phase of a client or server instance.
+type phase string
+
+const (
+	phaseIdle         phase = "idle"
+	phaseRegistration phase = "registration"
+	phaseLogin        phase = "login"
+	phaseBusy         phase = "busy" // a step is running
+)
+
+// sequencer enforces the order of protocol steps of a client instance in strict mode.
+// When strict mode is off every step is allowed and no phase is tracked.
+type sequencer struct {
+	mu     sync.Mutex
+	strict bool
+	phase  phase
+}
+
+// setStrict turns strict mode on or off and resets the instance to idle.
+func (sq *sequencer) setStrict(strict bool) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	sq.strict = strict
+	sq.phase = phaseIdle
+}
+
+// current returns the phase of the instance. It is always idle when strict mode is off.
+func (sq *sequencer) current() phase {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	if !sq.strict || sq.phase == "" {
+		return phaseIdle
+	}
+
+	return sq.phase
+}
+
+// enter starts the named step, which is only allowed in phase from.
+// Every successful enter must be followed by a deferred leave.
+func (sq *sequencer) enter(step string, from phase) error {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	if !sq.strict {
+		return nil
+	}
+
+	if sq.phase == "" {
+		sq.phase = phaseIdle
+	}
+
+	if sq.phase != from {
+		return newError(codeOutOfSequence, fmt.Sprintf("%s called out of sequence: instance is %s", step, sq.phase))
+	}
+
+	sq.phase = phaseBusy
+	return nil
+}
+
+// leave ends the running step. The instance moves to phase to when *errp is nil
+// and is reset to idle otherwise.
+func (sq *sequencer) leave(to phase, errp *error) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	if !sq.strict || sq.phase != phaseBusy {
+		return
+	}
+
+	if *errp != nil {
+		sq.phase = phaseIdle
+		return
+	}
+
+	sq.phase = to
+}
+
+// loginSequencer enforces the order of the login steps of a server in strict mode: a login state
+// is only accepted by loginFinish once, and only while the login it belongs to is pending.
+// Logins are tracked one by one until they expire, so any number of them can run at once and a
+// login that is never finished, e.g. after a wrong password, does not hold up the others.
+// Registrations are stateless on the server and always allowed. It is safe for concurrent use.
+type loginSequencer struct {
+	mu      sync.Mutex
+	strict  bool
+	pending pendingLogins[struct{}]
+}
+
+// setStrict turns strict mode on or off and forgets the pending logins.
+func (ls *loginSequencer) setStrict(strict bool) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	ls.strict = strict
+	ls.pending.clear()
+}
+
+// current returns login while strict mode is on and a login is pending, and idle otherwise.
+func (ls *loginSequencer) current() phase {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if !ls.strict || ls.pending.count() == 0 {
+		return phaseIdle
+	}
+
+	return phaseLogin
+}
+
+// begin records the login with the given expected client MAC as pending until expires.
+func (ls *loginSequencer) begin(expectedClientMac []byte, now, expires time.Time) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if !ls.strict {
+		return
+	}
+
+	ls.pending.add(expectedClientMac, struct{}{}, expires, now, nil)
+}
+
+// finish ends the pending login with the given expected client MAC. It fails when the login
+// was not started by this server, has expired or has already been finished.
+func (ls *loginSequencer) finish(expectedClientMac []byte, now time.Time) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if !ls.strict {
+		return nil
+	}
+
+	_, deadline, ok := ls.pending.take(expectedClientMac)
+	if !ok || !now.Before(deadline) {
+		return newError(codeOutOfSequence, "loginFinish called out of sequence: no pending login for this state")
+	}
+
+	return nil
+}
@@ -1,36 +1,100 @@
 package main
 
 import (
-	"errors"
+	"crypto/subtle"
+	"sync"
 
 	"github.com/cymony/cryptomony/opaque"
+	"github.com/cymony/cryptomony/utils"
+)
+
+var (
+	errServerNotInitialized = newError(codeNotInitialized, "server must be initialized first")
+	errOprfSeedMissing      = argError("oprfSeed", "oprf seed must be given, bound to the server or active in its seed set")
+	errOprfSeedMismatch     = argError("oprfSeed", "given oprf seed differs from the oprf seed bound to the server")
 )
 
 type server struct {
+	mu            sync.RWMutex
 	isInitialized bool
-	s             opaque.Server
 	sConf         *opaque.ServerConfiguration
+	suite         opaque.Suite
+	keys          serverKeyring
+	oprfSeed      []byte // optional, bound at initialization
+	logins        loginSequencer
+	sessions      sessionStore
+	sealer        stateSealer
+	timer         loginTimer
+	lockout       lockoutTracker
+	records       recordStore
+	seeds         oprfSeedSet
+	stats         serverStats
 }
 
 func newServer() *server {
-	return &server{isInitialized: false, sConf: nil, s: nil}
+	return &server{
+		isInitialized: false,
+		sConf:         nil,
+		suite:         nil,
+		oprfSeed:      nil,
+		sessions:      sessionStore{max: maxServerSessions},
+		records:       newMemoryRecordStore(),
+	}
 }
 
-// LoginFinish wasm wrapper for opaque.Server.ServerFinish
+// LoginFinish wasm wrapper for opaque.Suite.ServerFinish
 // Takes one argument, ke3Message []byte, returns sessionKey []byte
 // Prototype Go: LoginFinish(ke3Message []byte) []byte
 // Prototype JS: loginFinish(ke3Message: Uint8Array) Uint8Array
-func (s *server) LoginFinish(loginState []byte, ke3 []byte) ([]byte, error) {
-	if !s.IsInitialized() {
-		return nil, errors.New("server must be initialized first")
+func (s *server) LoginFinish(loginState []byte, ke3 []byte) (_ []byte, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.isInitialized {
+		return nil, errServerNotInitialized
 	}
 
+	now := s.timer.now()
+
+	loginState, err = s.sealer.open(stateServerLogin, s.sConf.OpaqueSuite, s.stateBinding(), loginState, "loginState", now)
+	if err != nil {
+		return nil, asLoginTimeout(err)
+	}
+	defer wipeBytes(loginState)
+
 	svLoginState := &opaque.ServerLoginState{}
-	if err := svLoginState.Decode(s.sConf.OpaqueSuite.New(), loginState); err != nil {
+	if err := svLoginState.Decode(s.suite, loginState); err != nil {
+		return nil, decodeError("loginState", err)
+	}
+
+	// The timeout is checked first: a login that timed out is gone from the strict mode
+	// sequencer as well, which would report it as out of sequence instead.
+	timeoutErr := s.timer.finish(svLoginState.ExpectedClientMac, s.sealer.enabled(), now)
+
+	if err := s.logins.finish(svLoginState.ExpectedClientMac, now); err != nil && timeoutErr == nil {
 		return nil, err
 	}
 
-	sessionKey, err := s.s.ServerFinish(svLoginState, ke3)
+	// Every login of a known state that does not succeed counts as a failure, malformed KE3 included.
+	defer func() {
+		s.lockout.finish(svLoginState.ExpectedClientMac, err == nil, now)
+		if err == nil {
+			s.stats.loginsSucceeded.Add(1)
+		} else {
+			s.stats.loginsFailed.Add(1)
+		}
+	}()
+
+	if timeoutErr != nil {
+		return nil, timeoutErr
+	}
+
+	ke3Message := &opaque.KE3{}
+	if err := ke3Message.Decode(s.suite, ke3); err != nil {
+		return nil, decodeError("ke3", err)
+	}
+
+	sessionKey, err := s.suite.ServerFinish(svLoginState, ke3Message)
 	if err != nil {
 		return nil, err
 	}
@@ -38,37 +102,425 @@ func (s *server) LoginFinish(loginState []byte, ke3 []byte) ([]byte, error) {
 	return sessionKey, nil
 }
 
-// LoginInit wasm wrapper for opaque.Server.ServerInit
+// LoginInit wasm wrapper for opaque.Suite.ServerInit
+// oprfSeed may be empty when a seed is bound to the server.
 func (s *server) LoginInit(record, ke1, oprfSeed []byte, credID, clientIdentity string) ([]byte, []byte, error) {
-	if !s.IsInitialized() {
-		return nil, nil, errors.New("server must be initialized first")
+	loginState, ke2, _, err := s.LoginInitWithKey(record, ke1, oprfSeed, "", credID, clientIdentity)
+	return loginState, ke2, err
+}
+
+// LoginInitWithKey is like LoginInit but uses the server key of serverKeyID, the key the record
+// was registered under, or the current key when serverKeyID is empty. It returns the key ID used.
+func (s *server) LoginInitWithKey(record, ke1, oprfSeed []byte, serverKeyID, credID, clientIdentity string) ([]byte, []byte, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.isInitialized {
+		return nil, nil, "", errServerNotInitialized
+	}
+
+	key, err := s.keys.get(serverKeyID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	seed, err := s.oprfSeedFor(oprfSeed)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	loginState, ke2, err := s.loginInit(record, ke1, seed, key, credID, clientIdentity)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return loginState, ke2, key.id, nil
+}
+
+// LoginInitKeyed is like LoginInitWithKey but uses the oprf seed of keyID, the key ID
+// returned by RegistrationEvalKeyed when the record was registered.
+func (s *server) LoginInitKeyed(record, ke1 []byte, keyID, serverKeyID, credID, clientIdentity string) ([]byte, []byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.isInitialized {
+		return nil, nil, errServerNotInitialized
+	}
+
+	seed, err := s.seeds.get(keyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := s.keys.get(serverKeyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.loginInit(record, ke1, seed, key, credID, clientIdentity)
+}
+
+// loginInit must be called with s.mu held.
+func (s *server) loginInit(record, ke1, seed []byte, key *serverKey, credID, clientIdentity string) ([]byte, []byte, error) {
+	if err := s.checkLockout(credID); err != nil {
+		return nil, nil, err
+	}
+
+	regRecord := &opaque.RegistrationRecord{}
+	if err := regRecord.Decode(s.suite, record); err != nil {
+		return nil, nil, decodeError("record", err)
+	}
+
+	loginState, ke2, err := s.serverInit(regRecord, ke1, seed, key, credID, clientIdentity)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.encodeLoginInit(loginState, ke2, credID)
+}
+
+// LoginInitUnknownUser answers a KE1 for a credential identifier without a registration record.
+// The returned KE2 is built from a fake record derived from the oprf seed and the credential identifier,
+// so it is indistinguishable from a real one, and the returned login state never accepts a KE3.
+// oprfSeed may be empty when a seed is bound to the server.
+func (s *server) LoginInitUnknownUser(ke1, oprfSeed []byte, credID, clientIdentity string) ([]byte, []byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.isInitialized {
+		return nil, nil, errServerNotInitialized
+	}
+
+	seed, err := s.oprfSeedFor(oprfSeed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.loginInitUnknownUser(ke1, seed, credID, clientIdentity)
+}
+
+// loginInitUnknownUser must be called with s.mu held.
+func (s *server) loginInitUnknownUser(ke1, seed []byte, credID, clientIdentity string) ([]byte, []byte, error) {
+	// Unknown users are locked like registered ones so that a lockout does not reveal registration.
+	if err := s.checkLockout(credID); err != nil {
+		return nil, nil, err
+	}
+
+	regRecord, err := fakeRecord(s.suite, seed, []byte(credID))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := s.keys.get("")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	loginState, ke2, err := s.serverInit(regRecord, ke1, seed, key, credID, clientIdentity)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Nobody can produce a KE3 for a random MAC.
+	loginState.ExpectedClientMac = utils.RandomBytes(s.suite.Nm())
+
+	return s.encodeLoginInit(loginState, ke2, credID)
+}
+
+// LoginInitSession is like LoginInitWithKey but keeps the login state inside the server
+// and returns a session handle for it instead.
+func (s *server) LoginInitSession(record, ke1, oprfSeed []byte, serverKeyID, credID, clientIdentity string) (string, []byte, error) {
+	loginState, ke2, _, err := s.LoginInitWithKey(record, ke1, oprfSeed, serverKeyID, credID, clientIdentity)
+	return s.keepSession(loginState, ke2, err)
+}
+
+// LoginInitUnknownUserSession is like LoginInitUnknownUser but keeps the login state inside the server
+// and returns a session handle for it instead.
+func (s *server) LoginInitUnknownUserSession(ke1, oprfSeed []byte, credID, clientIdentity string) (string, []byte, error) {
+	return s.keepSession(s.LoginInitUnknownUser(ke1, oprfSeed, credID, clientIdentity))
+}
+
+// LoginFinishSession is like LoginFinish but takes the login state of the session handle.
+// The state is wiped whether or not the login succeeds.
+func (s *server) LoginFinishSession(handle string, ke3 []byte) ([]byte, error) {
+	loginState, err := s.sessions.take(handle, s.timer.now())
+	if err != nil {
+		return nil, asLoginTimeout(err)
 	}
+	defer wipeBytes(loginState)
 
-	loginState, ke2, err := s.s.ServerInit(record, ke1, []byte(credID), []byte(clientIdentity), oprfSeed)
+	return s.LoginFinish(loginState, ke3)
+}
+
+func (s *server) keepSession(loginState, ke2 []byte, err error) (string, []byte, error) {
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := s.timer.now()
+
+	handle, err := s.sessions.put(loginState, now, now.Add(s.timer.lifetime()))
+	if err != nil {
+		wipeBytes(loginState)
+		return "", nil, err
+	}
+
+	return handle, ke2, nil
+}
+
+// SetRecordStore replaces the record store of the server. nil restores an empty in-memory store.
+func (s *server) SetRecordStore(store recordStore) {
+	if store == nil {
+		store = newMemoryRecordStore()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records = store
+}
+
+// RegisterUpload checks the registration record and puts it into the record store for credID.
+func (s *server) RegisterUpload(credID string, record []byte) error {
+	s.mu.RLock()
+	initialized, suite, store := s.isInitialized, s.suite, s.records
+	s.mu.RUnlock()
+
+	if !initialized {
+		return errServerNotInitialized
+	}
+
+	regRecord := &opaque.RegistrationRecord{}
+	if err := regRecord.Decode(suite, record); err != nil {
+		return decodeError("record", err)
+	}
+
+	return store.Put(credID, record)
+}
+
+// DeleteRecord removes the registration record of credID from the record store.
+func (s *server) DeleteRecord(credID string) error {
+	s.mu.RLock()
+	store := s.records
+	s.mu.RUnlock()
+
+	return store.Delete(credID)
+}
+
+// LoginInitByCredID is like LoginInitWithKey but takes the registration record of credID from the record store.
+// keyID selects the oprf seed of the seed set the record was registered with, as LoginInitKeyed does.
+// When it is empty the given or bound seed is used, or the active seed of the seed set.
+// Without a record it answers like LoginInitUnknownUser, so unknown credential identifiers are not revealed.
+func (s *server) LoginInitByCredID(credID string, ke1, oprfSeed []byte, keyID, serverKeyID, clientIdentity string) ([]byte, []byte, error) {
+	s.mu.RLock()
+	store := s.records
+	s.mu.RUnlock()
+
+	record, found, err := store.Get(credID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.isInitialized {
+		return nil, nil, errServerNotInitialized
+	}
+
+	seed, err := s.loginSeed(oprfSeed, keyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !found {
+		return s.loginInitUnknownUser(ke1, seed, credID, clientIdentity)
+	}
+
+	key, err := s.keys.get(serverKeyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.loginInit(record, ke1, seed, key, credID, clientIdentity)
+}
+
+// ChangePasswordInit starts the password change of credID: it evaluates the registration request
+// of the new password and answers the KE1 of the login with the old one, taking the current record
+// from the record store. The login uses the server key of serverKeyID, the key the current record was
+// registered under, or the current key when empty. The new record is registered under the current key.
+// With keyID the login uses the oprf seed of keyID, as LoginInitByCredID does, and the new record is
+// registered with the active seed, whose key ID is returned to be stored with the new record.
+// The login state is kept inside the server with credID and a session handle is returned.
+func (s *server) ChangePasswordInit(credID string, ke1, regRequest, oprfSeed []byte, keyID, serverKeyID, clientIdentity string) (string, []byte, []byte, string, error) {
+	var (
+		regRes   []byte
+		newKeyID string
+		err      error
+	)
+
+	if keyID == "" {
+		regRes, err = s.RegistrationEval(regRequest, oprfSeed, credID)
+	} else {
+		regRes, newKeyID, _, err = s.RegistrationEvalKeyed(regRequest, credID)
+	}
+	if err != nil {
+		return "", nil, nil, "", err
+	}
+
+	loginState, ke2, err := s.LoginInitByCredID(credID, ke1, oprfSeed, keyID, serverKeyID, clientIdentity)
+	if err != nil {
+		return "", nil, nil, "", err
+	}
+	defer wipeBytes(loginState)
+
+	state, err := encodeChangeState([]byte(credID), loginState)
+	if err != nil {
+		return "", nil, nil, "", err
+	}
+
+	now := s.timer.now()
+
+	handle, err := s.sessions.put(state, now, now.Add(s.timer.lifetime()))
+	if err != nil {
+		wipeBytes(state)
+		return "", nil, nil, "", err
+	}
+
+	return handle, ke2, regRes, newKeyID, nil
+}
+
+// ChangePasswordFinish verifies the KE3 of the password change of the session handle and only then
+// replaces the record of its credential identifier in the record store with the new one, in a single Put.
+// The old record stays in place when any step fails.
+func (s *server) ChangePasswordFinish(handle string, ke3, record []byte) ([]byte, error) {
+	state, err := s.sessions.take(handle, s.timer.now())
+	if err != nil {
+		return nil, asLoginTimeout(err)
+	}
+	defer wipeBytes(state)
+
+	credID, loginState, err := decodeChangeState(state)
+	if err != nil {
+		return nil, err
+	}
+	defer wipeBytes(loginState)
+
+	sessionKey, err := s.LoginFinish(loginState, ke3)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.RegisterUpload(string(credID), record); err != nil {
+		wipeBytes(sessionKey)
+		return nil, err
+	}
+
+	return sessionKey, nil
+}
+
+// checkLockout is lockout.check counting the refused logins.
+func (s *server) checkLockout(credID string) error {
+	if err := s.lockout.check(credID, s.timer.now()); err != nil {
+		s.stats.lockouts.Add(1)
+		return err
+	}
+	return nil
+}
+
+// serverInit must be called with s.mu held.
+func (s *server) serverInit(record *opaque.RegistrationRecord, ke1, oprfSeed []byte, key *serverKey, credID, clientIdentity string) (*opaque.ServerLoginState, *opaque.KE2, error) {
+	ke1Message, err := decodeKE1(s.suite, ke1)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	return s.suite.ServerInit(key.privKey, key.pubKey, record, ke1Message, []byte(credID), []byte(clientIdentity), s.sConf.ServerID, oprfSeed)
+}
+
+// encodeLoginInit must be called with s.mu held.
+func (s *server) encodeLoginInit(loginState *opaque.ServerLoginState, ke2 *opaque.KE2, credID string) ([]byte, []byte, error) {
 	encodedLoginState, err := loginState.Encode()
 	if err != nil {
 		return nil, nil, err
 	}
 
+	now := s.timer.now()
+	expires := now.Add(s.timer.lifetime())
+
+	s.timer.start(loginState.ExpectedClientMac, now)
+	s.lockout.begin(loginState.ExpectedClientMac, credID, now, expires)
+	s.logins.begin(loginState.ExpectedClientMac, now, expires)
+
+	encodedLoginState, err = s.sealer.seal(stateServerLogin, s.sConf.OpaqueSuite, s.stateBinding(), encodedLoginState, expires)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	encodedKE2, err := ke2.Encode()
 	if err != nil {
 		return nil, nil, err
 	}
 
+	s.stats.loginsStarted.Add(1)
 	return encodedLoginState, encodedKE2, nil
 }
 
-// RegistrationRes wasm wrapper for opaque.Server.CreateRegistrationResponse
+// RegistrationEval wasm wrapper for opaque.Suite.CreateRegistrationResponse
+// oprfSeed may be empty when a seed is bound to the server.
 func (s *server) RegistrationEval(regRequest, oprfSeed []byte, credID string) ([]byte, error) {
-	if !s.IsInitialized() {
-		return nil, errors.New("server must be initialized first")
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.isInitialized {
+		return nil, errServerNotInitialized
+	}
+
+	seed, err := s.oprfSeedFor(oprfSeed)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.registrationEval(regRequest, seed, credID)
+}
+
+// RegistrationEvalKeyed is like RegistrationEval but uses the active oprf seed of the server.
+// It returns the key ID of the seed, to be stored with the record and given to LoginInitKeyed,
+// and the key ID of the current server key the record is registered under.
+func (s *server) RegistrationEvalKeyed(regRequest []byte, credID string) ([]byte, string, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.isInitialized {
+		return nil, "", "", errServerNotInitialized
+	}
+
+	keyID, seed, err := s.seeds.current()
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	regRes, err := s.registrationEval(regRequest, seed, credID)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return regRes, keyID, s.keys.current, nil
+}
+
+// registrationEval registers under the current server key. It must be called with s.mu held.
+func (s *server) registrationEval(regRequest, seed []byte, credID string) ([]byte, error) {
+	regReq := &opaque.RegistrationRequest{}
+	if err := regReq.Decode(s.suite, regRequest); err != nil {
+		return nil, decodeError("registrationRequest", err)
 	}
 
-	regResponse, err := s.s.CreateRegistrationResponse(regRequest, []byte(credID), oprfSeed)
+	key, err := s.keys.get("")
+	if err != nil {
+		return nil, err
+	}
+
+	regResponse, err := s.suite.CreateRegistrationResponse(regReq, key.pubKey, []byte(credID), seed)
 	if err != nil {
 		return nil, err
 	}
@@ -77,44 +529,405 @@ func (s *server) RegistrationEval(regRequest, oprfSeed []byte, credID string) ([
 	if err != nil {
 		return nil, err
 	}
+
+	s.stats.registrations.Add(1)
 	return encodedRegRes, nil
 }
 
-// GenerateOprfSeed wasm wrapper for opaque.Server.GenerateOprfSeed
+// GenerateOprfSeed wasm wrapper for opaque.Suite.GenerateOprfSeed
 func (s *server) GenerateOprfSeed() ([]byte, error) {
-	if !s.IsInitialized() {
-		return nil, errors.New("server must be initialized first")
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.isInitialized {
+		return nil, errServerNotInitialized
 	}
 
-	oprfSeed := s.s.GenerateOprfSeed()
+	oprfSeed := s.suite.GenerateOprfSeed()
 	return oprfSeed, nil
 }
 
+// AddOprfSeed adds an oprf seed under keyID, or a new random seed when seed is empty.
+// The first seed added becomes the active one. It returns the seed.
+func (s *server) AddOprfSeed(keyID string, seed []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.isInitialized {
+		return nil, errServerNotInitialized
+	}
+
+	if len(seed) == 0 {
+		seed = s.suite.GenerateOprfSeed()
+	}
+
+	if len(seed) != s.suite.Nh() {
+		return nil, opaque.ErrOPRFSeedLength
+	}
+
+	if err := s.seeds.add(keyID, append([]byte(nil), seed...)); err != nil {
+		return nil, err
+	}
+
+	return seed, nil
+}
+
+// SetActiveOprfSeed makes the oprf seed of keyID the one used by new keyed registrations.
+func (s *server) SetActiveOprfSeed(keyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.isInitialized {
+		return errServerNotInitialized
+	}
+
+	return s.seeds.setActive(keyID)
+}
+
+// RemoveOprfSeed wipes the oprf seed of keyID. The write lock keeps the seed intact
+// for the logins that are still using it.
+func (s *server) RemoveOprfSeed(keyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.isInitialized {
+		return errServerNotInitialized
+	}
+
+	return s.seeds.remove(keyID)
+}
+
+// ListOprfSeeds returns the sorted key IDs of the oprf seeds and the active key ID.
+func (s *server) ListOprfSeeds() ([]string, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.isInitialized {
+		return nil, "", errServerNotInitialized
+	}
+
+	keyIDs, active := s.seeds.list()
+	return keyIDs, active, nil
+}
+
+// PublicKey returns the encoded public key of the server key of serverKeyID, or of the current key when empty.
+func (s *server) PublicKey(serverKeyID string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.isInitialized {
+		return nil, errServerNotInitialized
+	}
+
+	key, err := s.keys.get(serverKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return key.pubKey.MarshalBinary()
+}
+
+// asLoginTimeout turns the expiry of a login state into errLoginTimeout.
+func asLoginTimeout(err error) error {
+	if code, _ := classifyError(err); code == codeExpired {
+		return errLoginTimeout
+	}
+	return err
+}
+
+// stateBinding returns the data sealed login states are bound to: the server identity.
+// The server key is left out so that logins started before a key rotation can finish.
+// It must be called with s.mu held.
+func (s *server) stateBinding() []byte {
+	return s.sConf.ServerID
+}
+
+// oprfSeedFor returns the oprf seed to use for a call given the optional per-call seed.
+// Without a given or bound seed the active seed of the seed set is used.
+// It must be called with s.mu held.
+func (s *server) oprfSeedFor(given []byte) ([]byte, error) {
+	if len(s.oprfSeed) == 0 {
+		if len(given) != 0 {
+			return given, nil
+		}
+
+		if _, seed, err := s.seeds.current(); err == nil {
+			return seed, nil
+		}
+
+		return nil, errOprfSeedMissing
+	}
+
+	if len(given) != 0 && subtle.ConstantTimeCompare(given, s.oprfSeed) != 1 {
+		return nil, errOprfSeedMismatch
+	}
+
+	return s.oprfSeed, nil
+}
+
+// loginSeed returns the oprf seed of keyID in the seed set, or oprfSeedFor(given) when keyID is empty.
+// It must be called with s.mu held.
+func (s *server) loginSeed(given []byte, keyID string) ([]byte, error) {
+	if keyID == "" {
+		return s.oprfSeedFor(given)
+	}
+
+	if len(given) != 0 {
+		return nil, argError("oprfSeed", "oprf seed must not be given with a key ID")
+	}
+
+	return s.seeds.get(keyID)
+}
+
 func (s *server) IsInitialized() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	return s.isInitialized
 }
 
-// InitializeServer wasm wrapper for opaque.NewServer
-func (s *server) InitializeServer(suiteName, serverID string, privKey []byte) error {
-	sConf := &opaque.ServerConfiguration{}
+// destroy wipes the server private key and configuration and leaves the server uninitialized.
+func (s *server) destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	if s.sConf != nil {
+		wipeBytes(s.sConf.ServerPrivateKey)
+		wipeBytes(s.sConf.ServerID)
+	}
+
+	wipeBytes(s.oprfSeed)
+
+	s.isInitialized = false
+	s.sConf = nil
+	s.suite = nil
+	s.keys.clear()
+	s.oprfSeed = nil
+	s.records = newMemoryRecordStore()
+	s.logins.setStrict(false)
+	s.sessions.clear()
+	s.sealer.disable()
+	s.timer.clear()
+	s.lockout.clear()
+	s.seeds.clear()
+	s.stats.reset()
+}
+
+// InitializeServer initializes the server with the given configuration.
+// A new private key is generated when privKey is empty.
+// oprfSeed is bound to the server when given, or generated and bound when generateOprfSeed is set.
+func (s *server) InitializeServer(suiteName, serverID string, privKey, oprfSeed []byte, generateOprfSeed bool) error {
 	suiteID, err := strToSuite(suiteName)
 	if err != nil {
 		return err
 	}
 
-	sConf.OpaqueSuite = suiteID
-	sConf.ServerID = []byte(serverID)
-	sConf.ServerPrivateKey = privKey
+	if generateOprfSeed {
+		if len(oprfSeed) != 0 {
+			return argError("oprfSeed", "oprf seed must not be given when it is generated")
+		}
+
+		oprfSeed = newSuite(suiteID).GenerateOprfSeed()
+	}
+
+	return s.initialize(&opaque.ServerConfiguration{
+		OpaqueSuite:      suiteID,
+		ServerID:         []byte(serverID),
+		ServerPrivateKey: privKey,
+	}, oprfSeed, defaultServerKeyID, nil)
+}
+
+// ExportSetup serializes the server configuration, the whole server keyring and the oprf seed into a setup blob.
+// oprfSeed may be empty when a seed is bound to the server. A server that only uses its seed set is
+// exported without a seed to bind, so that the imported server keeps following the active seed.
+func (s *server) ExportSetup(oprfSeed []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.isInitialized {
+		return nil, errServerNotInitialized
+	}
+
+	var seed []byte
+
+	if len(oprfSeed) != 0 || len(s.oprfSeed) != 0 {
+		var err error
+		if seed, err = s.oprfSeedFor(oprfSeed); err != nil {
+			return nil, err
+		}
+
+		if len(seed) != s.suite.Nh() {
+			return nil, opaque.ErrOPRFSeedLength
+		}
+	} else if _, _, err := s.seeds.current(); err != nil {
+		return nil, errOprfSeedMissing
+	}
+
+	keyIDs, current := s.keys.list()
+
+	setup := &serverSetup{conf: s.sConf, oprfSeed: seed, currentKeyID: current}
+	for _, keyID := range keyIDs {
+		setup.keys = append(setup.keys, setupKey{id: keyID, privKey: s.keys.keys[keyID].encoded})
+	}
+
+	setup.seeds, setup.activeSeedID = s.seeds.snapshot()
+	defer func() {
+		for _, seed := range setup.seeds {
+			wipeBytes(seed.seed)
+		}
+	}()
+
+	return setup.Encode()
+}
+
+// ImportSetup initializes the server from a setup blob, binds the oprf seed stored in it
+// to the server and returns the seed, or nil when the blob has no seed to bind. The seed set
+// of the server is replaced by the one stored in the blob.
+func (s *server) ImportSetup(blob []byte) ([]byte, error) {
+	setup := &serverSetup{}
+	if err := setup.Decode(blob); err != nil {
+		return nil, err
+	}
+
+	if err := s.initialize(setup.conf, setup.oprfSeed, setup.currentKeyID, setup.keys); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.seeds.replace(setup.seeds, setup.activeSeedID)
+	s.mu.Unlock()
+
+	if len(setup.oprfSeed) == 0 {
+		return nil, nil
+	}
+
+	return setup.oprfSeed, nil
+}
+
+// initialize sets up the server with sConf, whose private key becomes the current key under currentKeyID,
+// and with the previous keys of the keyring, if any.
+func (s *server) initialize(sConf *opaque.ServerConfiguration, oprfSeed []byte, currentKeyID string, previous []setupKey) error {
+	suite := newSuite(sConf.OpaqueSuite)
 
-	sv, err := opaque.NewServer(sConf)
+	if len(oprfSeed) != 0 && len(oprfSeed) != suite.Nh() {
+		return opaque.ErrOPRFSeedLength
+	}
+
+	current, err := newServerKey(suite, currentKeyID, sConf.ServerPrivateKey)
 	if err != nil {
 		return err
 	}
+	sConf.ServerPrivateKey = current.encoded
+
+	keys := serverKeyring{}
+
+	for _, prev := range previous {
+		if prev.id == currentKeyID {
+			continue
+		}
+
+		key, err := newServerKey(suite, prev.id, prev.privKey)
+		if err != nil {
+			return err
+		}
+
+		if err := keys.add(key); err != nil {
+			return err
+		}
+	}
+
+	// Added last so that it is the current key.
+	if err := keys.add(current); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys.clear()
 
-	s.s = sv
 	s.isInitialized = true
 	s.sConf = sConf
+	s.suite = suite
+	s.keys = keys
+	s.oprfSeed = oprfSeed
 
 	return nil
 }
+
+// RotateServerKey adds a server key under keyID, or a new random one when privKey is empty,
+// and makes it the current key. The previous keys stay usable for logins until they are retired.
+// It returns the encoded public key of the new key.
+func (s *server) RotateServerKey(keyID string, privKey []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.isInitialized {
+		return nil, errServerNotInitialized
+	}
+
+	key, err := newServerKey(s.suite, keyID, append([]byte(nil), privKey...))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.keys.add(key); err != nil {
+		return nil, err
+	}
+
+	s.sConf.ServerPrivateKey = key.encoded
+
+	return key.pubKey.MarshalBinary()
+}
+
+// RetireServerKey wipes the server key of keyID. Records registered under it can not log in anymore.
+// The current key can not be retired.
+func (s *server) RetireServerKey(keyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.isInitialized {
+		return errServerNotInitialized
+	}
+
+	return s.keys.retire(keyID)
+}
+
+// ServerKeys returns the sorted key IDs of the server keys and the current key ID.
+func (s *server) ServerKeys() ([]string, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.isInitialized {
+		return nil, "", errServerNotInitialized
+	}
+
+	keyIDs, current := s.keys.list()
+	return keyIDs, current, nil
+}
+
+// generateServerKeyPair generates a new server key pair for the given suite.
+// It returns the encoded private key and public key.
+func generateServerKeyPair(suiteName string) ([]byte, []byte, error) {
+	suiteID, err := strToSuite(suiteName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privKey, err := newSuite(suiteID).GenerateKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encodedPrivKey, err := privKey.MarshalBinary()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encodedPubKey, err := privKey.Public().MarshalBinary()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encodedPrivKey, encodedPubKey, nil
+}
@@ -1,24 +1,22 @@
+//go:build js && wasm
+
 package main
 
 import (
-	"errors"
-	"math/rand"
+	"math"
 	"syscall/js"
 	"time"
-	"unsafe"
 )
 
 type serverManager struct {
-	servers map[string]*server
-	rndSrc  rand.Source
+	servers *registry[*server]
+	idArg   string // name of the first argument, the key of the server in servers
 }
 
 func newServerManager() *serverManager {
-	src := rand.NewSource(time.Now().UnixNano())
-
 	return &serverManager{
-		servers: make(map[string]*server),
-		rndSrc:  src,
+		servers: newRegistry("server", newServer),
+		idArg:   "identifier",
 	}
 }
 
@@ -26,66 +24,116 @@ func (sm *serverManager) exposeServer(rootModule js.Value) {
 	rootModule.Set("server", make(map[string]interface{}))
 	serverModule := rootModule.Get("server")
 
-	serverModule.Set("newServer", js.FuncOf(sm.NewServer))
+	serverModule.Set("newServer", js.FuncOf(sm.servers.JSCreate))
 	serverModule.Set("initServer", js.FuncOf(sm.InitializeServer))
+	sm.exposeMethods(serverModule)
+	serverModule.Set("destroyServer", js.FuncOf(sm.servers.JSDestroy))
+	serverModule.Set("destroyAll", js.FuncOf(sm.servers.JSDestroyAll))
+	serverModule.Set("listServers", js.FuncOf(sm.servers.JSList))
+	serverModule.Set("setMaxServers", js.FuncOf(sm.servers.JSSetMaxInstances))
+	serverModule.Set("generateServerKeyPair", js.FuncOf(sm.GenerateServerKeyPair))
+	serverModule.Set("deriveTenantSecrets", js.FuncOf(sm.DeriveTenantSecrets))
+}
+
+// exposeMethods sets the functions taking the key of a server as first argument on module.
+func (sm *serverManager) exposeMethods(serverModule js.Value) {
 	serverModule.Set("isInitialized", js.FuncOf(sm.IsInitialized))
 	serverModule.Set("generateOprfSeed", js.FuncOf(sm.GenerateOprfSeed))
 	serverModule.Set("registrationEval", js.FuncOf(sm.RegistrationEval))
 	serverModule.Set("loginInit", js.FuncOf(sm.LoginInit))
+	serverModule.Set("loginInitUnknownUser", js.FuncOf(sm.LoginInitUnknownUser))
 	serverModule.Set("loginFinish", js.FuncOf(sm.LoginFinish))
+	serverModule.Set("loginInitSession", js.FuncOf(sm.LoginInitSession))
+	serverModule.Set("loginInitUnknownUserSession", js.FuncOf(sm.LoginInitUnknownUserSession))
+	serverModule.Set("loginFinishSession", js.FuncOf(sm.LoginFinishSession))
+	serverModule.Set("getServerPublicKey", js.FuncOf(sm.GetServerPublicKey))
+	serverModule.Set("exportServerSetup", js.FuncOf(sm.ExportServerSetup))
+	serverModule.Set("importServerSetup", js.FuncOf(sm.ImportServerSetup))
+	serverModule.Set("enableStateSealing", js.FuncOf(sm.EnableStateSealing))
+	serverModule.Set("disableStateSealing", js.FuncOf(sm.DisableStateSealing))
+	serverModule.Set("setRecordStore", js.FuncOf(sm.SetRecordStore))
+	serverModule.Set("registerUpload", js.FuncOf(sm.RegisterUpload))
+	serverModule.Set("deleteRecord", js.FuncOf(sm.DeleteRecord))
+	serverModule.Set("loginInitByCredID", js.FuncOf(sm.LoginInitByCredID))
+	serverModule.Set("changePasswordInit", js.FuncOf(sm.ChangePasswordInit))
+	serverModule.Set("changePasswordFinish", js.FuncOf(sm.ChangePasswordFinish))
+	serverModule.Set("addOprfSeed", js.FuncOf(sm.AddOprfSeed))
+	serverModule.Set("setActiveOprfSeed", js.FuncOf(sm.SetActiveOprfSeed))
+	serverModule.Set("removeOprfSeed", js.FuncOf(sm.RemoveOprfSeed))
+	serverModule.Set("listOprfSeeds", js.FuncOf(sm.ListOprfSeeds))
+	serverModule.Set("registrationEvalKeyed", js.FuncOf(sm.RegistrationEvalKeyed))
+	serverModule.Set("loginInitKeyed", js.FuncOf(sm.LoginInitKeyed))
+	serverModule.Set("rotateServerKey", js.FuncOf(sm.RotateServerKey))
+	serverModule.Set("retireServerKey", js.FuncOf(sm.RetireServerKey))
+	serverModule.Set("listServerKeys", js.FuncOf(sm.ListServerKeys))
+	serverModule.Set("setLoginTimeout", js.FuncOf(sm.SetLoginTimeout))
+	serverModule.Set("setMaxSessions", js.FuncOf(sm.SetMaxSessions))
+	serverModule.Set("setClock", js.FuncOf(sm.SetClock))
+	serverModule.Set("setLockoutPolicy", js.FuncOf(sm.SetLockoutPolicy))
+	serverModule.Set("resetLockout", js.FuncOf(sm.ResetLockout))
+	serverModule.Set("exportLockoutState", js.FuncOf(sm.ExportLockoutState))
+	serverModule.Set("importLockoutState", js.FuncOf(sm.ImportLockoutState))
+	serverModule.Set("setStrictMode", js.FuncOf(sm.SetStrictMode))
+	serverModule.Set("getPhase", js.FuncOf(sm.GetPhase))
+	serverModule.Set("getStats", js.FuncOf(sm.GetStats))
 }
 
-// NewServer creates new empty server instance with identifier. It returns the identifier.
-func (sm *serverManager) NewServer(this js.Value, inputs []js.Value) any {
-	clid := sm.GenerateRandomID()
-	sm.servers[clid] = newServer()
-	return clid
-}
-
-// initServer(identifier: string, suiteName: string, serverID: string, privKey: Uint8Array) Promise<void>
+// initServer(identifier: string, suiteName: string, serverID: string, privKey: Uint8Array, oprfSeed?: Uint8Array | boolean) Promise<void>
+// oprfSeed is bound to the server when given. Passing true generates and binds a new seed.
 func (sm *serverManager) InitializeServer(this js.Value, inputs []js.Value) any {
 	runner := func(resolve js.Value, reject js.Value) {
-		sv, err := sm.getServer(inputs, 4)
+		sv, err := sm.servers.lookupBetween(inputs, 4, 5, sm.idArg)
 		if err != nil {
 			rejectErr(reject, err)
 			return
 		}
 
-		chosenSuite := inputs[1]
-		chosenServerID := inputs[2]
-		chosenPrivKey := inputs[3]
-
-		if err := checkIsString(chosenSuite, "suiteName"); err != nil {
+		if err := initServerFromInputs(sv, inputs); err != nil {
 			rejectErr(reject, err)
 			return
 		}
 
-		if err := checkIsString(chosenServerID, "serverID"); err != nil {
-			rejectErr(reject, err)
-			return
-		}
+		resolve.Invoke()
+	}
+
+	return promiser(runner)
+}
+
+// initServerFromInputs initializes sv with the initServer arguments following the first one.
+func initServerFromInputs(sv *server, inputs []js.Value) error {
+	chosenSuite := inputs[1]
+	chosenServerID := inputs[2]
+	chosenPrivKey := inputs[3]
+
+	if err := checkIsString(chosenSuite, "suiteName"); err != nil {
+		return err
+	}
+
+	if err := checkIsString(chosenServerID, "serverID"); err != nil {
+		return err
+	}
+
+	privKey, err := copyOptionalBytesToGo(chosenPrivKey, "privKey")
+	if err != nil {
+		return err
+	}
 
-		var privKey []byte
+	var oprfSeed []byte
 
-		if chosenPrivKey.IsNull() || chosenPrivKey.IsUndefined() || chosenPrivKey.IsNaN() {
-			privKey = nil
+	generateOprfSeed := false
+
+	if len(inputs) == 5 {
+		if inputs[4].Type() == js.TypeBoolean {
+			generateOprfSeed = inputs[4].Bool()
 		} else {
-			privKey, err = copyBytesToGo(chosenPrivKey, "privKey")
+			oprfSeed, err = copyOptionalBytesToGo(inputs[4], "oprfSeed")
 			if err != nil {
-				rejectErr(reject, err)
-				return
+				return err
 			}
 		}
-
-		if err := sv.InitializeServer(chosenSuite.String(), chosenServerID.String(), privKey); err != nil {
-			rejectErr(reject, err)
-			return
-		}
-
-		resolve.Invoke()
 	}
 
-	return promiser(runner)
+	return sv.InitializeServer(chosenSuite.String(), chosenServerID.String(), privKey, oprfSeed, generateOprfSeed)
 }
 
 // isInitialized(identifier: string) Promise<boolean>
@@ -125,7 +173,8 @@ func (sm *serverManager) GenerateOprfSeed(this js.Value, inputs []js.Value) any
 	return promiser(runner)
 }
 
-// registrationEval(identifier: string, registrationRequest: Uint8Array, oprfSeed: Uint8Array, credentialIdentifier: string) Promise<Uint8Array>
+// registrationEval(identifier: string, registrationRequest: Uint8Array, oprfSeed: Uint8Array | null, credentialIdentifier: string) Promise<Uint8Array>
+// oprfSeed may be null when a seed is bound to the server.
 func (sm *serverManager) RegistrationEval(this js.Value, inputs []js.Value) any {
 	runner := func(resolve js.Value, reject js.Value) {
 		sv, err := sm.getServer(inputs, 4)
@@ -144,7 +193,7 @@ func (sm *serverManager) RegistrationEval(this js.Value, inputs []js.Value) any
 			return
 		}
 
-		oprfSeed, err := copyBytesToGo(chosenOprfSeed, "oprfSeed")
+		oprfSeed, err := copyOptionalBytesToGo(chosenOprfSeed, "oprfSeed")
 		if err != nil {
 			rejectErr(reject, err)
 			return
@@ -172,55 +221,126 @@ func (sm *serverManager) RegistrationEval(this js.Value, inputs []js.Value) any
 * loginInit(identifier: string,
 *   record: Uint8Array,
 *   ke1: Uint8Array,
-*   oprfSeed Uint8Array,
+*   oprfSeed Uint8Array | null,
 *   credentialID string,
-*   clientIdentity string) Promise<{
+*   clientIdentity string,
+*   serverKeyID?: string | null) Promise<{
 *	loginState: Uint8Array,
-*	ke2: Uint8Array}>
+*	ke2: Uint8Array,
+*	serverKeyID: string}>
+* oprfSeed may be null when a seed is bound to the server. serverKeyID selects the server key
+* the record was registered under and defaults to the current key. Resolves with the key ID used.
  */
 func (sm *serverManager) LoginInit(this js.Value, inputs []js.Value) any {
 	runner := func(resolve js.Value, reject js.Value) {
-		sv, err := sm.getServer(inputs, 6)
+		sv, err := sm.servers.lookupBetween(inputs, 6, 7, sm.idArg)
 		if err != nil {
 			rejectErr(reject, err)
 			return
 		}
 
-		chosenRecord := inputs[1]
-		chosenKE1 := inputs[2]
-		chosenOprfSeed := inputs[3]
-		chosenCredID := inputs[4]
-		chosenClientIdentity := inputs[5]
+		args, err := parseLoginInitInputs(inputs[1:], true)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
 
-		record, err := copyBytesToGo(chosenRecord, "record")
+		serverKeyID, err := optionalServerKeyID(inputs, 6)
 		if err != nil {
 			rejectErr(reject, err)
 			return
 		}
 
-		ke1, err := copyBytesToGo(chosenKE1, "ke1")
+		loginState, ke2, usedKeyID, err := sv.LoginInitWithKey(args.record, args.ke1, args.oprfSeed, serverKeyID, args.credID, args.clientIdentity)
 		if err != nil {
 			rejectErr(reject, err)
 			return
 		}
 
-		oprfSeed, err := copyBytesToGo(chosenOprfSeed, "oprfSeed")
+		returnObj := make(map[string]interface{})
+		returnObj["loginState"] = copyBytesToJS(loginState)
+		returnObj["ke2"] = copyBytesToJS(ke2)
+		returnObj["serverKeyID"] = usedKeyID
+
+		resolve.Invoke(returnObj)
+	}
+
+	return promiser(runner)
+}
+
+/*
+* loginInitSession(identifier: string,
+*   record: Uint8Array,
+*   ke1: Uint8Array,
+*   oprfSeed Uint8Array | null,
+*   credentialID string,
+*   clientIdentity string,
+*   serverKeyID?: string | null) Promise<{
+*	session: string,
+*	ke2: Uint8Array}>
+* Like loginInit but keeps the login state inside the server and resolves with a session handle for it.
+ */
+func (sm *serverManager) LoginInitSession(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.servers.lookupBetween(inputs, 6, 7, sm.idArg)
 		if err != nil {
 			rejectErr(reject, err)
 			return
 		}
 
-		if err := checkIsString(chosenCredID, "credentialID"); err != nil {
+		args, err := parseLoginInitInputs(inputs[1:], true)
+		if err != nil {
 			rejectErr(reject, err)
 			return
 		}
 
-		if err := checkIsString(chosenClientIdentity, "clientIdentity"); err != nil {
+		serverKeyID, err := optionalServerKeyID(inputs, 6)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		session, ke2, err := sv.LoginInitSession(args.record, args.ke1, args.oprfSeed, serverKeyID, args.credID, args.clientIdentity)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		returnObj := make(map[string]interface{})
+		returnObj["session"] = session
+		returnObj["ke2"] = copyBytesToJS(ke2)
+
+		resolve.Invoke(returnObj)
+	}
+
+	return promiser(runner)
+}
+
+/*
+* loginInitUnknownUser(identifier: string,
+*   ke1: Uint8Array,
+*   oprfSeed Uint8Array | null,
+*   credentialID string,
+*   clientIdentity string) Promise<{
+*	loginState: Uint8Array,
+*	ke2: Uint8Array}>
+* Answers a KE1 for a credential ID without a record. loginFinish always fails for the returned state.
+ */
+func (sm *serverManager) LoginInitUnknownUser(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 5)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		args, err := parseLoginInitInputs(inputs[1:], false)
+		if err != nil {
 			rejectErr(reject, err)
 			return
 		}
 
-		loginState, ke2, err := sv.LoginInit(record, ke1, oprfSeed, chosenCredID.String(), chosenClientIdentity.String())
+		loginState, ke2, err := sv.LoginInitUnknownUser(args.ke1, args.oprfSeed, args.credID, args.clientIdentity)
 		if err != nil {
 			rejectErr(reject, err)
 			return
@@ -236,6 +356,46 @@ func (sm *serverManager) LoginInit(this js.Value, inputs []js.Value) any {
 	return promiser(runner)
 }
 
+/*
+* loginInitUnknownUserSession(identifier: string,
+*   ke1: Uint8Array,
+*   oprfSeed Uint8Array | null,
+*   credentialID string,
+*   clientIdentity string) Promise<{
+*	session: string,
+*	ke2: Uint8Array}>
+* Like loginInitUnknownUser but keeps the login state inside the server and resolves with a session handle for it.
+ */
+func (sm *serverManager) LoginInitUnknownUserSession(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 5)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		args, err := parseLoginInitInputs(inputs[1:], false)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		session, ke2, err := sv.LoginInitUnknownUserSession(args.ke1, args.oprfSeed, args.credID, args.clientIdentity)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		returnObj := make(map[string]interface{})
+		returnObj["session"] = session
+		returnObj["ke2"] = copyBytesToJS(ke2)
+
+		resolve.Invoke(returnObj)
+	}
+
+	return promiser(runner)
+}
+
 // loginFinish(identifier: string, loginState: Uint8Array, ke3: Uint8Array) Promise<Uint8Array>
 func (sm *serverManager) LoginFinish(this js.Value, inputs []js.Value) any {
 	runner := func(resolve js.Value, reject js.Value) {
@@ -273,47 +433,1280 @@ func (sm *serverManager) LoginFinish(this js.Value, inputs []js.Value) any {
 	return promiser(runner)
 }
 
-func (sm *serverManager) getServer(inputs []js.Value, inputLen int) (*server, error) {
-	if err := checkInputLen(inputs, inputLen); err != nil {
-		return nil, err
+// loginFinishSession(identifier: string, session: string, ke3: Uint8Array) Promise<Uint8Array>
+// The login state of the session is wiped whether or not the login succeeds.
+func (sm *serverManager) LoginFinishSession(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 3)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenSession := inputs[1]
+		chosenKE3 := inputs[2]
+
+		if err := checkIsString(chosenSession, "session"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		ke3, err := copyBytesToGo(chosenKE3, "ke3")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		sessionKey, err := sv.LoginFinishSession(chosenSession.String(), ke3)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		dataJS := copyBytesToJS(sessionKey)
+		resolve.Invoke(dataJS)
 	}
 
-	svIdentifier := inputs[0]
+	return promiser(runner)
+}
 
-	if err := checkIsString(svIdentifier, "identifier"); err != nil {
-		return nil, err
+// generateServerKeyPair(suiteName: string) Promise<{privateKey: Uint8Array, publicKey: Uint8Array}>
+func (sm *serverManager) GenerateServerKeyPair(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		if err := checkInputLen(inputs, 1); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenSuite := inputs[0]
+		if err := checkIsString(chosenSuite, "suiteName"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		privKey, pubKey, err := generateServerKeyPair(chosenSuite.String())
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		returnObj := make(map[string]interface{})
+		returnObj["privateKey"] = copyBytesToJS(privKey)
+		returnObj["publicKey"] = copyBytesToJS(pubKey)
+
+		resolve.Invoke(returnObj)
 	}
 
-	sv, ok := sm.servers[svIdentifier.String()]
-	if !ok {
-		return nil, errors.New("server not found")
+	return promiser(runner)
+}
+
+/*
+* deriveTenantSecrets(suiteName: string, masterSecret: Uint8Array, tenant: string) Promise<{
+*	oprfSeed: Uint8Array,
+*	privateKey: Uint8Array,
+*	publicKey: Uint8Array}>
+* Derives the oprf seed and server key pair of the tenant from a master secret of at least 32 bytes.
+* The same inputs always give the same secrets.
+ */
+func (sm *serverManager) DeriveTenantSecrets(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		if err := checkInputLen(inputs, 3); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenSuite := inputs[0]
+		chosenTenant := inputs[2]
+
+		if err := checkIsString(chosenSuite, "suiteName"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsString(chosenTenant, "tenant"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		suiteID, err := strToSuite(chosenSuite.String())
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		masterSecret, err := copyBytesToGo(inputs[1], "masterSecret")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+		defer wipeBytes(masterSecret)
+
+		secrets, err := deriveTenantSecrets(suiteID, masterSecret, chosenTenant.String())
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		returnObj := make(map[string]interface{})
+		returnObj["oprfSeed"] = copyBytesToJS(secrets.oprfSeed)
+		returnObj["privateKey"] = copyBytesToJS(secrets.privKey)
+		returnObj["publicKey"] = copyBytesToJS(secrets.pubKey)
+
+		resolve.Invoke(returnObj)
 	}
 
-	return sv, nil
+	return promiser(runner)
 }
 
-// GenerateRandomID generates random and unique server identifier
-func (sm *serverManager) GenerateRandomID() string {
-	n := 8
+// getServerPublicKey(identifier: string, serverKeyID?: string | null) Promise<Uint8Array>
+// Resolves with the public key of the server key of serverKeyID, or of the current key.
+func (sm *serverManager) GetServerPublicKey(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.servers.lookupBetween(inputs, 1, 2, sm.idArg)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
 
-	b := make([]byte, n)
-	// A src.Int63() generates 63 random bits, enough for letterIdxMax characters!
-	for i, cache, remain := n-1, sm.rndSrc.Int63(), letterIdxMax; i >= 0; {
-		if remain == 0 {
-			cache, remain = sm.rndSrc.Int63(), letterIdxMax
+		var serverKeyID string
+		if len(inputs) == 2 && !isNullish(inputs[1]) {
+			if err := checkIsString(inputs[1], "serverKeyID"); err != nil {
+				rejectErr(reject, err)
+				return
+			}
+			serverKeyID = inputs[1].String()
 		}
-		if idx := int(cache & letterIdxMask); idx < len(letterBytes) {
-			b[i] = letterBytes[idx]
-			i--
+
+		pubKey, err := sv.PublicKey(serverKeyID)
+		if err != nil {
+			rejectErr(reject, err)
+			return
 		}
-		cache >>= letterIdxBits
-		remain--
+
+		dataJS := copyBytesToJS(pubKey)
+		resolve.Invoke(dataJS)
 	}
 
-	generatedString := *(*string)(unsafe.Pointer(&b))
-	_, ok := sm.servers[generatedString]
-	if ok {
-		return sm.GenerateRandomID()
+	return promiser(runner)
+}
+
+// exportServerSetup(identifier: string, oprfSeed?: Uint8Array) Promise<Uint8Array>
+// oprfSeed may be omitted when a seed is bound to the server.
+// The blob also holds the oprf seeds added with addOprfSeed. A server that only uses those
+// is exported without a seed to bind.
+func (sm *serverManager) ExportServerSetup(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.servers.lookupBetween(inputs, 1, 2, sm.idArg)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		var oprfSeed []byte
+
+		if len(inputs) == 2 {
+			oprfSeed, err = copyOptionalBytesToGo(inputs[1], "oprfSeed")
+			if err != nil {
+				rejectErr(reject, err)
+				return
+			}
+		}
+
+		setup, err := sv.ExportSetup(oprfSeed)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		dataJS := copyBytesToJS(setup)
+		resolve.Invoke(dataJS)
 	}
-	return generatedString
+
+	return promiser(runner)
+}
+
+// importServerSetup(identifier: string, setup: Uint8Array) Promise<Uint8Array | null>
+// Initializes the server from the setup blob, binds the stored oprf seed and resolves with it,
+// or with null when the blob has none. The oprf seeds added with addOprfSeed are replaced
+// by the ones stored in the blob.
+func (sm *serverManager) ImportServerSetup(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 2)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		setup, err := copyBytesToGo(inputs[1], "setup")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		oprfSeed, err := sv.ImportSetup(setup)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if oprfSeed == nil {
+			resolve.Invoke(js.Null())
+			return
+		}
+
+		dataJS := copyBytesToJS(oprfSeed)
+		resolve.Invoke(dataJS)
+	}
+
+	return promiser(runner)
+}
+
+// enableStateSealing(identifier: string, key: Uint8Array | null) Promise<Uint8Array>
+// Seals the login states returned by the server with an AEAD key held by the server, bound to the
+// server identity. A new key is generated when key is null. Resolves with the key in use,
+// which can be given to other server instances sharing the states.
+func (sm *serverManager) EnableStateSealing(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 2)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		key, err := copyOptionalBytesToGo(inputs[1], "key")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		key, err = sv.sealer.setKey(key)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke(copyBytesToJS(key))
+	}
+	return promiser(runner)
+}
+
+// disableStateSealing(identifier: string) Promise<void>
+func (sm *serverManager) DisableStateSealing(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 1)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		sv.sealer.disable()
+		resolve.Invoke()
+	}
+	return promiser(runner)
+}
+
+/*
+* setRecordStore(identifier: string, store: {
+*	get(credentialID: string): Uint8Array | null | Promise<Uint8Array | null>,
+*	put(credentialID: string, record: Uint8Array): void | Promise<void>,
+*	delete(credentialID: string): void | Promise<void>} | null) Promise<void>
+* Replaces the record store used by registerUpload and loginInitByCredID.
+* null restores an empty in-memory store, which is the default.
+ */
+func (sm *serverManager) SetRecordStore(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 2)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if isNullish(inputs[1]) {
+			sv.SetRecordStore(nil)
+			resolve.Invoke()
+			return
+		}
+
+		store, err := newJSRecordStore(inputs[1])
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		sv.SetRecordStore(store)
+		resolve.Invoke()
+	}
+	return promiser(runner)
+}
+
+// registerUpload(identifier: string, credentialID: string, record: Uint8Array) Promise<void>
+// Checks the registration record and puts it into the record store.
+func (sm *serverManager) RegisterUpload(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 3)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenCredID := inputs[1]
+		chosenRecord := inputs[2]
+
+		if err := checkIsString(chosenCredID, "credentialID"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		record, err := copyBytesToGo(chosenRecord, "record")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := sv.RegisterUpload(chosenCredID.String(), record); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke()
+	}
+	return promiser(runner)
+}
+
+// deleteRecord(identifier: string, credentialID: string) Promise<void>
+func (sm *serverManager) DeleteRecord(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 2)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsString(inputs[1], "credentialID"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := sv.DeleteRecord(inputs[1].String()); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke()
+	}
+	return promiser(runner)
+}
+
+/*
+* loginInitByCredID(identifier: string,
+*   credentialID: string,
+*   ke1: Uint8Array,
+*   clientIdentity: string,
+*   oprfSeed?: Uint8Array | null,
+*   serverKeyID?: string | null,
+*   keyID?: string | null) Promise<{
+*	loginState: Uint8Array,
+*	ke2: Uint8Array}>
+* Like loginInit but takes the record from the record store. Unknown credential IDs are answered
+* like loginInitUnknownUser. oprfSeed may be omitted when a seed is bound to the server.
+* keyID selects the oprf seed the record was registered with, as in loginInitKeyed.
+ */
+func (sm *serverManager) LoginInitByCredID(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.servers.lookupBetween(inputs, 4, 7, sm.idArg)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenCredID := inputs[1]
+		chosenKE1 := inputs[2]
+		chosenClientIdentity := inputs[3]
+
+		if err := checkIsString(chosenCredID, "credentialID"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		ke1, err := copyBytesToGo(chosenKE1, "ke1")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsString(chosenClientIdentity, "clientIdentity"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		var oprfSeed []byte
+		if len(inputs) >= 5 {
+			oprfSeed, err = copyOptionalBytesToGo(inputs[4], "oprfSeed")
+			if err != nil {
+				rejectErr(reject, err)
+				return
+			}
+		}
+
+		serverKeyID, err := optionalServerKeyID(inputs, 5)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		keyID, err := optionalOprfKeyID(inputs, 6)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		loginState, ke2, err := sv.LoginInitByCredID(chosenCredID.String(), ke1, oprfSeed, keyID, serverKeyID, chosenClientIdentity.String())
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		returnObj := make(map[string]interface{})
+		returnObj["loginState"] = copyBytesToJS(loginState)
+		returnObj["ke2"] = copyBytesToJS(ke2)
+
+		resolve.Invoke(returnObj)
+	}
+	return promiser(runner)
+}
+
+/*
+* changePasswordInit(identifier: string,
+*   credentialID: string,
+*   ke1: Uint8Array,
+*   registrationRequest: Uint8Array,
+*   clientIdentity: string,
+*   oprfSeed?: Uint8Array | null,
+*   serverKeyID?: string | null,
+*   keyID?: string | null) Promise<{
+*	session: string,
+*	ke2: Uint8Array,
+*	registrationResponse: Uint8Array,
+*	keyID: string | null}>
+* Answers the login with the old password, taking the record from the record store, and evaluates
+* the registration request of the new password. oprfSeed may be omitted when a seed is bound to the server.
+* serverKeyID selects the server key the current record was registered under and defaults to the
+* current key. The new record is registered under the current key.
+* keyID selects the oprf seed the current record was registered with. The new record is then registered
+* with the active seed, whose key ID is resolved as keyID, and null otherwise.
+ */
+func (sm *serverManager) ChangePasswordInit(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.servers.lookupBetween(inputs, 5, 8, sm.idArg)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenCredID := inputs[1]
+		chosenClientIdentity := inputs[4]
+
+		if err := checkIsString(chosenCredID, "credentialID"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		ke1, err := copyBytesToGo(inputs[2], "ke1")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		regRequest, err := copyBytesToGo(inputs[3], "registrationRequest")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsString(chosenClientIdentity, "clientIdentity"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		var oprfSeed []byte
+		if len(inputs) >= 6 {
+			oprfSeed, err = copyOptionalBytesToGo(inputs[5], "oprfSeed")
+			if err != nil {
+				rejectErr(reject, err)
+				return
+			}
+		}
+
+		serverKeyID, err := optionalServerKeyID(inputs, 6)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		keyID, err := optionalOprfKeyID(inputs, 7)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		session, ke2, regRes, newKeyID, err := sv.ChangePasswordInit(chosenCredID.String(), ke1, regRequest, oprfSeed, keyID, serverKeyID, chosenClientIdentity.String())
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		returnObj := make(map[string]interface{})
+		returnObj["session"] = session
+		returnObj["ke2"] = copyBytesToJS(ke2)
+		returnObj["registrationResponse"] = copyBytesToJS(regRes)
+		returnObj["keyID"] = nil
+		if newKeyID != "" {
+			returnObj["keyID"] = newKeyID
+		}
+
+		resolve.Invoke(returnObj)
+	}
+	return promiser(runner)
+}
+
+// changePasswordFinish(identifier: string, session: string, ke3: Uint8Array, record: Uint8Array) Promise<Uint8Array>
+// Verifies the KE3 of the login with the old password and only then replaces the record in the record store.
+// Resolves with the session key.
+func (sm *serverManager) ChangePasswordFinish(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 4)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenSession := inputs[1]
+
+		if err := checkIsString(chosenSession, "session"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		ke3, err := copyBytesToGo(inputs[2], "ke3")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		record, err := copyBytesToGo(inputs[3], "record")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		sessionKey, err := sv.ChangePasswordFinish(chosenSession.String(), ke3, record)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke(copyBytesToJS(sessionKey))
+	}
+	return promiser(runner)
+}
+
+// addOprfSeed(identifier: string, keyID: string, oprfSeed: Uint8Array | null) Promise<Uint8Array>
+// Adds an oprf seed to the seed set of the server, or a new random one when oprfSeed is null.
+// The first seed added becomes the active one. Resolves with the seed.
+func (sm *serverManager) AddOprfSeed(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 3)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenKeyID := inputs[1]
+
+		if err := checkIsString(chosenKeyID, "keyID"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		oprfSeed, err := copyOptionalBytesToGo(inputs[2], "oprfSeed")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		oprfSeed, err = sv.AddOprfSeed(chosenKeyID.String(), oprfSeed)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke(copyBytesToJS(oprfSeed))
+	}
+	return promiser(runner)
+}
+
+// setActiveOprfSeed(identifier: string, keyID: string) Promise<void>
+// New registrations through registrationEvalKeyed use the active seed.
+func (sm *serverManager) SetActiveOprfSeed(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 2)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsString(inputs[1], "keyID"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := sv.SetActiveOprfSeed(inputs[1].String()); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke()
+	}
+	return promiser(runner)
+}
+
+// removeOprfSeed(identifier: string, keyID: string) Promise<void>
+// Wipes the seed. Records registered with it can not log in anymore. The active seed can not be removed.
+func (sm *serverManager) RemoveOprfSeed(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 2)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsString(inputs[1], "keyID"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := sv.RemoveOprfSeed(inputs[1].String()); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke()
+	}
+	return promiser(runner)
+}
+
+// listOprfSeeds(identifier: string) Promise<{keyIDs: string[], active: string | null}>
+func (sm *serverManager) ListOprfSeeds(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 1)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		keyIDs, active, err := sv.ListOprfSeeds()
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		keyIDsJS := make([]interface{}, len(keyIDs))
+		for i, keyID := range keyIDs {
+			keyIDsJS[i] = keyID
+		}
+
+		returnObj := make(map[string]interface{})
+		returnObj["keyIDs"] = keyIDsJS
+		returnObj["active"] = nil
+		if active != "" {
+			returnObj["active"] = active
+		}
+
+		resolve.Invoke(returnObj)
+	}
+	return promiser(runner)
+}
+
+/*
+* registrationEvalKeyed(identifier: string, registrationRequest: Uint8Array, credentialIdentifier: string) Promise<{
+*	registrationResponse: Uint8Array,
+*	keyID: string,
+*	serverKeyID: string}>
+* Like registrationEval but uses the active oprf seed. Store keyID with the record and pass it to loginInitKeyed.
+* serverKeyID is the current server key the record is registered under.
+ */
+func (sm *serverManager) RegistrationEvalKeyed(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 3)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenCredID := inputs[2]
+
+		regReq, err := copyBytesToGo(inputs[1], "registrationRequest")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsString(chosenCredID, "credentialIdentifier"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		regResponse, keyID, serverKeyID, err := sv.RegistrationEvalKeyed(regReq, chosenCredID.String())
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		returnObj := make(map[string]interface{})
+		returnObj["registrationResponse"] = copyBytesToJS(regResponse)
+		returnObj["keyID"] = keyID
+		returnObj["serverKeyID"] = serverKeyID
+
+		resolve.Invoke(returnObj)
+	}
+	return promiser(runner)
+}
+
+/*
+* loginInitKeyed(identifier: string,
+*   record: Uint8Array,
+*   ke1: Uint8Array,
+*   keyID: string,
+*   credentialID string,
+*   clientIdentity string,
+*   serverKeyID?: string | null) Promise<{
+*	loginState: Uint8Array,
+*	ke2: Uint8Array}>
+* Like loginInit but uses the oprf seed of keyID, as returned by registrationEvalKeyed for the record.
+* serverKeyID is the server key ID returned along with it and defaults to the current key.
+ */
+func (sm *serverManager) LoginInitKeyed(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.servers.lookupBetween(inputs, 6, 7, sm.idArg)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenKeyID := inputs[3]
+		chosenCredID := inputs[4]
+		chosenClientIdentity := inputs[5]
+
+		record, err := copyBytesToGo(inputs[1], "record")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		ke1, err := copyBytesToGo(inputs[2], "ke1")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsString(chosenKeyID, "keyID"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsString(chosenCredID, "credentialID"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsString(chosenClientIdentity, "clientIdentity"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		serverKeyID, err := optionalServerKeyID(inputs, 6)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		loginState, ke2, err := sv.LoginInitKeyed(record, ke1, chosenKeyID.String(), serverKeyID, chosenCredID.String(), chosenClientIdentity.String())
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		returnObj := make(map[string]interface{})
+		returnObj["loginState"] = copyBytesToJS(loginState)
+		returnObj["ke2"] = copyBytesToJS(ke2)
+
+		resolve.Invoke(returnObj)
+	}
+	return promiser(runner)
+}
+
+// rotateServerKey(identifier: string, serverKeyID: string, privKey: Uint8Array | null) Promise<Uint8Array>
+// Adds a server key, or a new random one when privKey is null, and makes it the current key.
+// Previous keys stay usable through the serverKeyID of the loginInit functions until retired. Resolves with the public key.
+func (sm *serverManager) RotateServerKey(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 3)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenKeyID := inputs[1]
+
+		if err := checkIsString(chosenKeyID, "serverKeyID"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		privKey, err := copyOptionalBytesToGo(inputs[2], "privKey")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		pubKey, err := sv.RotateServerKey(chosenKeyID.String(), privKey)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke(copyBytesToJS(pubKey))
+	}
+	return promiser(runner)
+}
+
+// retireServerKey(identifier: string, serverKeyID: string) Promise<void>
+// Wipes the server key. Records registered under it can not log in anymore. The current key can not be retired.
+func (sm *serverManager) RetireServerKey(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 2)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsString(inputs[1], "serverKeyID"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := sv.RetireServerKey(inputs[1].String()); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke()
+	}
+	return promiser(runner)
+}
+
+// listServerKeys(identifier: string) Promise<{serverKeyIDs: string[], current: string}>
+func (sm *serverManager) ListServerKeys(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 1)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		keyIDs, current, err := sv.ServerKeys()
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		keyIDsJS := make([]interface{}, len(keyIDs))
+		for i, keyID := range keyIDs {
+			keyIDsJS[i] = keyID
+		}
+
+		returnObj := make(map[string]interface{})
+		returnObj["serverKeyIDs"] = keyIDsJS
+		returnObj["current"] = current
+
+		resolve.Invoke(returnObj)
+	}
+	return promiser(runner)
+}
+
+// setLoginTimeout(identifier: string, timeoutMs: number) Promise<void>
+// loginFinish rejects with ERR_TIMEOUT once timeoutMs has passed since loginInit. Zero removes the timeout.
+// While a timeout is set, unsealed login states are only accepted by the server instance that created them.
+func (sm *serverManager) SetLoginTimeout(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 2)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsNumber(inputs[1], "timeoutMs"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := sv.timer.setTimeout(time.Duration(inputs[1].Int()) * time.Millisecond); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke()
+	}
+	return promiser(runner)
+}
+
+// setMaxSessions(identifier: string, max: number) Promise<void>
+// Bounds the number of session handles the server keeps for logins in flight. Defaults to 65536.
+func (sm *serverManager) SetMaxSessions(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 2)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsNumber(inputs[1], "max"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := sv.sessions.setMax(inputs[1].Int()); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke()
+	}
+	return promiser(runner)
+}
+
+// setClock(identifier: string, now: (() => number) | null) Promise<void>
+// Replaces the clock of the server, e.g. in tests. now returns the milliseconds since the unix epoch.
+// While now throws or returns anything but a finite number the system clock is used.
+// null restores the system clock.
+func (sm *serverManager) SetClock(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 2)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenClock := inputs[1]
+
+		if isNullish(chosenClock) {
+			sv.timer.setClock(nil)
+			resolve.Invoke()
+			return
+		}
+
+		if chosenClock.Type() != js.TypeFunction {
+			rejectErr(reject, argError("now", "now argument must be function or null"))
+			return
+		}
+
+		sv.timer.setClock(func() time.Time {
+			ms, err := invokeJS(chosenClock)
+			if err != nil || ms.Type() != js.TypeNumber || math.IsNaN(ms.Float()) || math.IsInf(ms.Float(), 0) {
+				return time.Now()
+			}
+			return time.UnixMilli(int64(ms.Float()))
+		})
+
+		resolve.Invoke()
+	}
+	return promiser(runner)
+}
+
+/*
+* setLockoutPolicy(identifier: string, policy: {
+*	maxFailures: number,
+*	backoffMs?: number[],
+*	lockoutMs?: number} | null) Promise<void>
+* Counts failed logins per credential ID: loginFinish calls that fail and logins not finished before their
+* state expires, as a client with a wrong password never sends a KE3. After a failure loginInit is refused with ERR_LOCKED
+* for the backoff of that failure, and after maxFailures failures for lockoutMs, or until resetLockout
+* when lockoutMs is zero. A successful login clears the failures, and the failures of a credential
+* that is not locked are forgotten a day after the last one. null turns the policy off.
+ */
+func (sm *serverManager) SetLockoutPolicy(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 2)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		var policy *lockoutPolicy
+
+		if !isNullish(inputs[1]) {
+			policy, err = parseLockoutPolicy(inputs[1])
+			if err != nil {
+				rejectErr(reject, err)
+				return
+			}
+		}
+
+		if err := sv.lockout.setPolicy(policy); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke()
+	}
+	return promiser(runner)
+}
+
+// resetLockout(identifier: string, credentialID: string) Promise<void>
+func (sm *serverManager) ResetLockout(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 2)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsString(inputs[1], "credentialID"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := sv.lockout.reset(inputs[1].String()); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke()
+	}
+	return promiser(runner)
+}
+
+// exportLockoutState(identifier: string) Promise<Uint8Array>
+// Serializes the failure counts and lockouts for persistence.
+func (sm *serverManager) ExportLockoutState(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 1)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		state, err := sv.lockout.export()
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke(copyBytesToJS(state))
+	}
+	return promiser(runner)
+}
+
+// importLockoutState(identifier: string, state: Uint8Array) Promise<void>
+// Replaces the failure counts and lockouts with the ones of a blob created by exportLockoutState.
+func (sm *serverManager) ImportLockoutState(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 2)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		state, err := copyBytesToGo(inputs[1], "state")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := sv.lockout.load(state); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke()
+	}
+	return promiser(runner)
+}
+
+// setStrictMode(identifier: string, enabled: boolean) Promise<void>
+// In strict mode loginFinish rejects with ERR_OUT_OF_SEQUENCE a login state that is not pending on this
+// server, i.e. not handed out by it, expired or already finished. Logins are tracked one by one, so
+// concurrent logins are allowed. Changing the mode forgets the pending logins.
+func (sm *serverManager) SetStrictMode(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 2)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsBool(inputs[1], "enabled"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		sv.logins.setStrict(inputs[1].Bool())
+		resolve.Invoke()
+	}
+	return promiser(runner)
+}
+
+// getPhase(identifier: string) Promise<'idle' | 'login'>
+// Resolves with login while strict mode is on and a login is pending.
+func (sm *serverManager) GetPhase(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 1)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke(string(sv.logins.current()))
+	}
+	return promiser(runner)
+}
+
+/*
+* getStats(identifier: string) Promise<{
+*	registrations: number,
+*	loginsStarted: number,
+*	loginsSucceeded: number,
+*	loginsFailed: number,
+*	lockouts: number}>
+* Counts the protocol outcomes of the server since it was created.
+ */
+func (sm *serverManager) GetStats(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		sv, err := sm.getServer(inputs, 1)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke(sv.stats.snapshot())
+	}
+	return promiser(runner)
+}
+
+func (sm *serverManager) getServer(inputs []js.Value, inputLen int) (*server, error) {
+	return sm.servers.lookup(inputs, inputLen, sm.idArg)
+}
+
+// optionalServerKeyID returns the server key ID at inputs[i], or an empty string, meaning the current key,
+// when it is omitted or nullish.
+func optionalServerKeyID(inputs []js.Value, i int) (string, error) {
+	if len(inputs) <= i || isNullish(inputs[i]) {
+		return "", nil
+	}
+
+	if err := checkIsString(inputs[i], "serverKeyID"); err != nil {
+		return "", err
+	}
+
+	return inputs[i].String(), nil
+}
+
+// optionalOprfKeyID returns the oprf seed key ID at inputs[i], or an empty string when it is omitted or nullish.
+func optionalOprfKeyID(inputs []js.Value, i int) (string, error) {
+	if len(inputs) <= i || isNullish(inputs[i]) {
+		return "", nil
+	}
+
+	if err := checkIsString(inputs[i], "keyID"); err != nil {
+		return "", err
+	}
+
+	return inputs[i].String(), nil
+}
+
+// loginInitInputs are the arguments shared by the login init functions.
+type loginInitInputs struct {
+	record         []byte
+	ke1            []byte
+	oprfSeed       []byte
+	credID         string
+	clientIdentity string
+}
+
+// parseLoginInitInputs parses [record,] ke1, oprfSeed, credentialID and clientIdentity.
+func parseLoginInitInputs(inputs []js.Value, withRecord bool) (*loginInitInputs, error) {
+	args := &loginInitInputs{}
+
+	if withRecord {
+		record, err := copyBytesToGo(inputs[0], "record")
+		if err != nil {
+			return nil, err
+		}
+
+		args.record = record
+		inputs = inputs[1:]
+	}
+
+	ke1, err := copyBytesToGo(inputs[0], "ke1")
+	if err != nil {
+		return nil, err
+	}
+
+	oprfSeed, err := copyOptionalBytesToGo(inputs[1], "oprfSeed")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkIsString(inputs[2], "credentialID"); err != nil {
+		return nil, err
+	}
+
+	if err := checkIsString(inputs[3], "clientIdentity"); err != nil {
+		return nil, err
+	}
+
+	args.ke1 = ke1
+	args.oprfSeed = oprfSeed
+	args.credID = inputs[2].String()
+	args.clientIdentity = inputs[3].String()
+
+	return args, nil
+}
+
+// parseLockoutPolicy parses the lockout policy object of setLockoutPolicy.
+func parseLockoutPolicy(obj js.Value) (*lockoutPolicy, error) {
+	if obj.Type() != js.TypeObject {
+		return nil, argError("policy", "policy argument must be object or null")
+	}
+
+	maxFailures := obj.Get("maxFailures")
+	if err := checkIsNumber(maxFailures, "maxFailures"); err != nil {
+		return nil, err
+	}
+
+	policy := &lockoutPolicy{maxFailures: maxFailures.Int()}
+
+	if backoff := obj.Get("backoffMs"); !isNullish(backoff) {
+		if err := checkArrType(backoff, "Array", "backoffMs"); err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < backoff.Length(); i++ {
+			if err := checkIsNumber(backoff.Index(i), "backoffMs"); err != nil {
+				return nil, err
+			}
+
+			policy.backoff = append(policy.backoff, time.Duration(backoff.Index(i).Int())*time.Millisecond)
+		}
+	}
+
+	if lockout := obj.Get("lockoutMs"); !isNullish(lockout) {
+		if err := checkIsNumber(lockout, "lockoutMs"); err != nil {
+			return nil, err
+		}
+
+		policy.lockout = time.Duration(lockout.Int()) * time.Millisecond
+	}
+
+	return policy, nil
 }
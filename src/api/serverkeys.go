@@ -0,0 +1,125 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/cymony/cryptomony/opaque"
+)
+
+// defaultServerKeyID is the key ID of the key a server is initialized with.
+const defaultServerKeyID = "default"
+
+var errServerKeyNotFound = &apiError{code: codeNotFound, argName: "serverKeyID", msg: "no server key with this key ID"}
+
+// serverKey is an AKE key pair of the server.
+type serverKey struct {
+	id      string
+	privKey *opaque.PrivateKey
+	pubKey  *opaque.PublicKey
+	encoded []byte // encoded private key
+}
+
+// newServerKey decodes the private key, or generates one when encodedPrivKey is empty.
+func newServerKey(suite opaque.Suite, keyID string, encodedPrivKey []byte) (*serverKey, error) {
+	if len(keyID) == 0 || len(keyID) > maxKeyIDLen {
+		return nil, argError("serverKeyID", "key ID must be between 1 and %d bytes", maxKeyIDLen)
+	}
+
+	var privKey *opaque.PrivateKey
+
+	if len(encodedPrivKey) == 0 {
+		generated, err := suite.GenerateKeyPair()
+		if err != nil {
+			return nil, err
+		}
+
+		encoded, err := generated.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+
+		privKey = generated
+		encodedPrivKey = encoded
+	} else {
+		privKey = &opaque.PrivateKey{}
+		if err := privKey.UnmarshalBinary(suite, encodedPrivKey); err != nil {
+			return nil, decodeError("privKey", err)
+		}
+	}
+
+	return &serverKey{id: keyID, privKey: privKey, pubKey: privKey.Public(), encoded: encodedPrivKey}, nil
+}
+
+// serverKeyring holds the AKE keys of a server by key ID. Logins use the current key unless
+// another one is selected, so a record registered under an older key keeps working until
+// that key is retired. It is not safe for concurrent use, the server guards it with s.mu.
+type serverKeyring struct {
+	keys    map[string]*serverKey
+	current string
+}
+
+// add stores key and makes it the current key.
+func (kr *serverKeyring) add(key *serverKey) error {
+	if _, ok := kr.keys[key.id]; ok {
+		return argError("serverKeyID", "a server key with key ID %q already exists", key.id)
+	}
+
+	if kr.keys == nil {
+		kr.keys = make(map[string]*serverKey)
+	}
+
+	kr.keys[key.id] = key
+	kr.current = key.id
+
+	return nil
+}
+
+// get returns the key of keyID, or the current key when keyID is empty.
+func (kr *serverKeyring) get(keyID string) (*serverKey, error) {
+	if keyID == "" {
+		keyID = kr.current
+	}
+
+	key, ok := kr.keys[keyID]
+	if !ok {
+		return nil, errServerKeyNotFound
+	}
+
+	return key, nil
+}
+
+// retire wipes and forgets the key of keyID. The current key can not be retired.
+func (kr *serverKeyring) retire(keyID string) error {
+	key, ok := kr.keys[keyID]
+	if !ok {
+		return errServerKeyNotFound
+	}
+
+	if keyID == kr.current {
+		return argError("serverKeyID", "the current server key can not be retired")
+	}
+
+	wipeBytes(key.encoded)
+	delete(kr.keys, keyID)
+	return nil
+}
+
+// list returns the sorted key IDs and the current key ID.
+func (kr *serverKeyring) list() ([]string, string) {
+	keyIDs := make([]string, 0, len(kr.keys))
+	for keyID := range kr.keys {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+
+	return keyIDs, kr.current
+}
+
+// clear wipes and forgets every key.
+func (kr *serverKeyring) clear() {
+	for keyID, key := range kr.keys {
+		wipeBytes(key.encoded)
+		delete(kr.keys, keyID)
+	}
+	kr.current = ""
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+const (
+	// sessionLifetime is how long pending login states, kept or sealed, stay valid by default.
+	sessionLifetime = 5 * time.Minute
+	// maxSessions bounds the number of pending login states kept by a client instance.
+	maxSessions = 1024
+	// maxServerSessions is the default bound on the pending login states kept by a server instance.
+	// A server holds one for every login in flight, finished or not, so it needs far more room.
+	maxServerSessions = 1 << 16
+)
+
+var (
+	errSessionNotFound = &apiError{code: codeNotFound, argName: "session", msg: "session not found"}
+	errSessionExpired  = &apiError{code: codeExpired, argName: "session", msg: "session has expired"}
+	errMaxSessions     = newError(codeLimitExceeded, "maximum number of pending sessions reached")
+)
+
+type session struct {
+	handle  string
+	state   []byte
+	expires time.Time
+}
+
+// sessionQueue orders sessions by expiry, soonest first. It may hold sessions already taken.
+type sessionQueue []*session
+
+func (q sessionQueue) Len() int           { return len(q) }
+func (q sessionQueue) Less(i, j int) bool { return q[i].expires.Before(q[j].expires) }
+func (q sessionQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *sessionQueue) Push(x any)        { *q = append(*q, x.(*session)) }
+
+func (q *sessionQueue) Pop() any {
+	old := *q
+	last := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return last
+}
+
+// sessionStore keeps encoded protocol states inside the module so that only
+// their handles are handed out to JS. Expired states are wiped on every put and take.
+// It is safe for concurrent use.
+type sessionStore struct {
+	mu       sync.Mutex
+	max      int // 0 means maxSessions
+	sessions map[string]*session
+	queue    sessionQueue
+}
+
+// setMax bounds the number of states kept at once. States already kept are not dropped.
+func (st *sessionStore) setMax(n int) error {
+	if n < 1 {
+		return argError("max", "maximum number of sessions must be positive")
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.max = n
+	return nil
+}
+
+// put stores the state until expires and returns its handle. The store takes ownership of state.
+func (st *sessionStore) put(state []byte, now, expires time.Time) (string, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.prune(now)
+
+	limit := st.max
+	if limit == 0 {
+		limit = maxSessions
+	}
+
+	if len(st.sessions) >= limit {
+		return "", errMaxSessions
+	}
+
+	if st.sessions == nil {
+		st.sessions = make(map[string]*session)
+	}
+
+	handle, err := generateID(func(id string) bool {
+		_, ok := st.sessions[id]
+		return ok
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sess := &session{handle: handle, state: state, expires: expires}
+	st.sessions[handle] = sess
+	heap.Push(&st.queue, sess)
+
+	return handle, nil
+}
+
+// take removes the state of the handle and returns it. The caller must wipe the state.
+func (st *sessionStore) take(handle string, now time.Time) ([]byte, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	sess, ok := st.sessions[handle]
+	if !ok {
+		st.prune(now)
+		return nil, errSessionNotFound
+	}
+
+	delete(st.sessions, handle)
+	st.prune(now)
+
+	if !now.Before(sess.expires) {
+		wipeBytes(sess.state)
+		return nil, errSessionExpired
+	}
+
+	return sess.state, nil
+}
+
+// clear wipes and removes every state.
+func (st *sessionStore) clear() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for handle, sess := range st.sessions {
+		wipeBytes(sess.state)
+		delete(st.sessions, handle)
+	}
+	st.queue = nil
+}
+
+// prune wipes and removes the expired states. It must be called with st.mu held.
+func (st *sessionStore) prune(now time.Time) {
+	for len(st.queue) > 0 && !now.Before(st.queue[0].expires) {
+		sess := heap.Pop(&st.queue).(*session)
+		if st.sessions[sess.handle] == sess {
+			wipeBytes(sess.state)
+			delete(st.sessions, sess.handle)
+		}
+	}
+
+	// Drop the sessions taken since, once they make up most of the queue.
+	if len(st.queue) > 2*len(st.sessions)+64 {
+		live := st.queue[:0]
+		for _, sess := range st.queue {
+			if st.sessions[sess.handle] == sess {
+				live = append(live, sess)
+			}
+		}
+		for i := len(live); i < len(st.queue); i++ {
+			st.queue[i] = nil
+		}
+		st.queue = live
+		heap.Init(&st.queue)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// TestServerSessions finishes logins kept behind session handles after some time, a second time
+// and after the server is destroyed.
+func TestServerSessions(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		elapsed  time.Duration
+		twice    bool
+		destroy  bool
+		wantCode errorCode
+	}{
+		{name: "in time", elapsed: sessionLifetime - time.Second},
+		{name: "expired", elapsed: sessionLifetime, wantCode: codeTimeout},
+		{name: "expired under timeout", timeout: time.Minute, elapsed: time.Minute, wantCode: codeTimeout},
+		{name: "finished twice", twice: true, wantCode: codeNotFound},
+		{name: "destroyed", destroy: true, wantCode: codeNotFound},
+	}
+
+	for _, tt := range tests {
+		now := time.Unix(1700000000, 0)
+
+		sv, cl, record := newTestLogin(t)
+		sv.timer.setClock(func() time.Time { return now })
+
+		if tt.timeout != 0 {
+			if err := sv.timer.setTimeout(tt.timeout); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		loginState, ke1, err := cl.LoginInit("password")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		handle, ke2, err := sv.LoginInitSession(record, ke1, nil, "", "alice", "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ke3, _, _, err := cl.LoginFinish(loginState, ke2, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		now = now.Add(tt.elapsed)
+
+		if tt.twice {
+			if _, err := sv.LoginFinishSession(handle, ke3); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if tt.destroy {
+			sv.destroy()
+		}
+
+		if _, err := sv.LoginFinishSession(handle, ke3); errorCodeOf(err) != tt.wantCode {
+			t.Errorf("%s: got %v, want code %q", tt.name, err, tt.wantCode)
+		}
+	}
+}
+
+// TestSessionStoreWipe checks that the states of expired sessions and of a cleared store are wiped.
+func TestSessionStoreWipe(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name  string
+		after func(st *sessionStore)
+	}{
+		{name: "expired", after: func(st *sessionStore) {
+			st.mu.Lock()
+			defer st.mu.Unlock()
+			st.prune(now.Add(time.Minute))
+		}},
+		{name: "cleared", after: func(st *sessionStore) { st.clear() }},
+	}
+
+	for _, tt := range tests {
+		st := &sessionStore{}
+		state := bytes.Repeat([]byte{1}, 32)
+
+		handle, err := st.put(state, now, now.Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tt.after(st)
+
+		if !bytes.Equal(state, make([]byte, len(state))) {
+			t.Errorf("%s: state not wiped", tt.name)
+		}
+
+		if _, err := st.take(handle, now); errorCodeOf(err) != codeNotFound {
+			t.Errorf("%s: got %v, want code %q", tt.name, err, codeNotFound)
+		}
+	}
+}
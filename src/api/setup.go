@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/cymony/cryptomony/opaque"
+)
+
+// Server setup blob layout:
+//
+//	magic[4] = "COSS"
+//	version[1]
+//	suite[2]
+//	serverID<0..2^16-1>
+//	oprfSeed<0..2^16-1>
+//	currentKeyID<0..2^16-1>
+//	count[2]
+//	count times:
+//		keyID<0..2^16-1>
+//		privateKey<0..2^16-1>
+//	activeSeedID<0..2^16-1>
+//	seedCount[2]
+//	seedCount times:
+//		seedID<0..2^16-1>
+//		seed<0..2^16-1>
+//
+// oprfSeed is the seed bound to the server and is empty when none is, in which case the seed set
+// must not be. activeSeedID is empty exactly when the seed set is.
+// All integers are big-endian and each vector is prefixed with a 2 byte length.
+const (
+	setupMagic   = "COSS"
+	setupVersion = 1
+)
+
+var errInvalidSetup = &apiError{code: codeDecode, argName: "setup", msg: "invalid server setup"}
+
+// setupKey is a server key pair stored in a setup blob.
+type setupKey struct {
+	id      string
+	privKey []byte
+}
+
+// setupSeed is an oprf seed of the seed set stored in a setup blob.
+type setupSeed struct {
+	id   string
+	seed []byte
+}
+
+// serverSetup contains everything required to restore a server instance.
+// conf.ServerPrivateKey is the private key of the current key.
+type serverSetup struct {
+	conf         *opaque.ServerConfiguration
+	oprfSeed     []byte
+	currentKeyID string
+	keys         []setupKey // the whole keyring, current key included
+	activeSeedID string
+	seeds        []setupSeed
+}
+
+// Encode serializes the serverSetup into the versioned setup blob.
+func (ss *serverSetup) Encode() ([]byte, error) {
+	if ss.conf == nil || len(ss.keys) == 0 || len(ss.keys) > 0xffff || len(ss.seeds) > 0xffff {
+		return nil, errInvalidSetup
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(setupMagic)
+	buf.WriteByte(setupVersion)
+
+	if err := binary.Write(buf, binary.BigEndian, uint16(ss.conf.OpaqueSuite)); err != nil {
+		return nil, err
+	}
+
+	for _, field := range [][]byte{ss.conf.ServerID, ss.oprfSeed, []byte(ss.currentKeyID)} {
+		if err := writeVector(buf, field); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := binary.Write(buf, binary.BigEndian, uint16(len(ss.keys))); err != nil {
+		return nil, err
+	}
+
+	for _, key := range ss.keys {
+		if err := writeVector(buf, []byte(key.id)); err != nil {
+			return nil, err
+		}
+
+		if err := writeVector(buf, key.privKey); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writeVector(buf, []byte(ss.activeSeedID)); err != nil {
+		return nil, err
+	}
+
+	if err := binary.Write(buf, binary.BigEndian, uint16(len(ss.seeds))); err != nil {
+		return nil, err
+	}
+
+	for _, seed := range ss.seeds {
+		if err := writeVector(buf, []byte(seed.id)); err != nil {
+			return nil, err
+		}
+
+		if err := writeVector(buf, seed.seed); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decode deserializes and validates the setup blob into the serverSetup struct.
+func (ss *serverSetup) Decode(data []byte) error {
+	r := bytes.NewReader(data)
+
+	magic := make([]byte, len(setupMagic))
+	if _, err := r.Read(magic); err != nil || string(magic) != setupMagic {
+		return fmt.Errorf("%w: unknown format", errInvalidSetup)
+	}
+
+	version, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("%w: missing version", errInvalidSetup)
+	}
+
+	if version != setupVersion {
+		return fmt.Errorf("%w: unsupported version %d", errInvalidSetup, version)
+	}
+
+	var suiteNum uint16
+	if err := binary.Read(r, binary.BigEndian, &suiteNum); err != nil {
+		return fmt.Errorf("%w: missing suite", errInvalidSetup)
+	}
+
+	suiteID := opaque.Identifier(suiteNum)
+	if err := checkSuiteID(suiteID); err != nil {
+		return fmt.Errorf("%w: %s", errInvalidSetup, err.Error())
+	}
+
+	fields := make([][]byte, 3)
+	for i := range fields {
+		fields[i], err = readVector(r)
+		if err != nil {
+			return fmt.Errorf("%w: truncated data", errInvalidSetup)
+		}
+	}
+
+	serverID, oprfSeed, currentKeyID := fields[0], fields[1], string(fields[2])
+
+	var count uint16
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return fmt.Errorf("%w: missing key count", errInvalidSetup)
+	}
+
+	var keys []setupKey
+	for i := uint16(0); i < count; i++ {
+		keyID, err := readVector(r)
+		if err != nil {
+			return fmt.Errorf("%w: truncated data", errInvalidSetup)
+		}
+
+		privKey, err := readVector(r)
+		if err != nil {
+			return fmt.Errorf("%w: truncated data", errInvalidSetup)
+		}
+
+		keys = append(keys, setupKey{id: string(keyID), privKey: privKey})
+	}
+
+	active, err := readVector(r)
+	if err != nil {
+		return fmt.Errorf("%w: missing active seed ID", errInvalidSetup)
+	}
+	activeSeedID := string(active)
+
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return fmt.Errorf("%w: missing seed count", errInvalidSetup)
+	}
+
+	var seeds []setupSeed
+	for i := uint16(0); i < count; i++ {
+		seedID, err := readVector(r)
+		if err != nil {
+			return fmt.Errorf("%w: truncated data", errInvalidSetup)
+		}
+
+		seed, err := readVector(r)
+		if err != nil {
+			return fmt.Errorf("%w: truncated data", errInvalidSetup)
+		}
+
+		seeds = append(seeds, setupSeed{id: string(seedID), seed: seed})
+	}
+
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: trailing data", errInvalidSetup)
+	}
+
+	suite := newSuite(suiteID)
+
+	var currentPrivKey []byte
+	seen := make(map[string]struct{}, len(keys))
+
+	for _, key := range keys {
+		if len(key.id) == 0 || len(key.id) > maxKeyIDLen {
+			return fmt.Errorf("%w: invalid key ID", errInvalidSetup)
+		}
+
+		if _, ok := seen[key.id]; ok {
+			return fmt.Errorf("%w: duplicate key ID %q", errInvalidSetup, key.id)
+		}
+		seen[key.id] = struct{}{}
+
+		if len(key.privKey) != suite.Nsk() {
+			return fmt.Errorf("%w: unexpected private key length", errInvalidSetup)
+		}
+
+		if err := (&opaque.PrivateKey{}).UnmarshalBinary(suite, key.privKey); err != nil {
+			return fmt.Errorf("%w: invalid private key", errInvalidSetup)
+		}
+
+		if key.id == currentKeyID {
+			currentPrivKey = key.privKey
+		}
+	}
+
+	if currentPrivKey == nil {
+		return fmt.Errorf("%w: current key missing from keyring", errInvalidSetup)
+	}
+
+	if len(oprfSeed) != 0 && len(oprfSeed) != suite.Nh() {
+		return fmt.Errorf("%w: unexpected oprf seed length", errInvalidSetup)
+	}
+
+	if len(oprfSeed) == 0 && len(seeds) == 0 {
+		return fmt.Errorf("%w: missing oprf seed", errInvalidSetup)
+	}
+
+	activeFound := false
+	seenSeeds := make(map[string]struct{}, len(seeds))
+
+	for _, seed := range seeds {
+		if len(seed.id) == 0 || len(seed.id) > maxKeyIDLen {
+			return fmt.Errorf("%w: invalid seed ID", errInvalidSetup)
+		}
+
+		if _, ok := seenSeeds[seed.id]; ok {
+			return fmt.Errorf("%w: duplicate seed ID %q", errInvalidSetup, seed.id)
+		}
+		seenSeeds[seed.id] = struct{}{}
+
+		if len(seed.seed) != suite.Nh() {
+			return fmt.Errorf("%w: unexpected oprf seed length", errInvalidSetup)
+		}
+
+		if seed.id == activeSeedID {
+			activeFound = true
+		}
+	}
+
+	if (activeSeedID == "" && len(seeds) != 0) || (activeSeedID != "" && !activeFound) {
+		return fmt.Errorf("%w: active seed missing from seed set", errInvalidSetup)
+	}
+
+	ss.conf = &opaque.ServerConfiguration{
+		ServerID:         serverID,
+		ServerPrivateKey: currentPrivKey,
+		OpaqueSuite:      suiteID,
+	}
+	ss.oprfSeed = oprfSeed
+	ss.currentKeyID = currentKeyID
+	ss.keys = keys
+	ss.activeSeedID = activeSeedID
+	ss.seeds = seeds
+
+	return nil
+}
+
+func writeVector(buf *bytes.Buffer, data []byte) error {
+	if len(data) > 0xffff {
+		return errors.New("vector too long")
+	}
+
+	if err := binary.Write(buf, binary.BigEndian, uint16(len(data))); err != nil {
+		return err
+	}
+
+	buf.Write(data)
+	return nil
+}
+
+func readVector(r *bytes.Reader) ([]byte, error) {
+	var l uint16
+	if err := binary.Read(r, binary.BigEndian, &l); err != nil {
+		return nil, err
+	}
+
+	if int(l) > r.Len() {
+		return nil, errors.New("vector length exceeds data")
+	}
+
+	data := make([]byte, l)
+	if _, err := r.Read(data); err != nil && l > 0 {
+		return nil, err
+	}
+
+	return data, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import "sync/atomic"
+
+// serverStats counts the protocol outcomes of a server. It is safe for concurrent use.
+type serverStats struct {
+	registrations   atomic.Uint64 // registration requests evaluated
+	loginsStarted   atomic.Uint64 // KE2 messages returned, unknown users included
+	loginsSucceeded atomic.Uint64
+	loginsFailed    atomic.Uint64 // KE3 messages refused for a known login state
+	lockouts        atomic.Uint64 // logins refused by the lockout policy
+}
+
+// snapshot returns the counters by their JS names.
+func (st *serverStats) snapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"registrations":   st.registrations.Load(),
+		"loginsStarted":   st.loginsStarted.Load(),
+		"loginsSucceeded": st.loginsSucceeded.Load(),
+		"loginsFailed":    st.loginsFailed.Load(),
+		"lockouts":        st.lockouts.Load(),
+	}
+}
+
+func (st *serverStats) reset() {
+	st.registrations.Store(0)
+	st.loginsStarted.Store(0)
+	st.loginsSucceeded.Store(0)
+	st.loginsFailed.Store(0)
+	st.lockouts.Store(0)
+}
@@ -0,0 +1,197 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"syscall/js"
+)
+
+// tenantManager keeps one server per tenant under the tenant name. Every tenant has its own
+// configuration, oprf seeds, server keys, record store, lockout counters and stats, and the
+// server functions take the tenant name where the server module takes a server identifier.
+type tenantManager struct {
+	*serverManager
+}
+
+func newTenantManager() *tenantManager {
+	return &tenantManager{
+		serverManager: &serverManager{
+			servers: newRegistry("tenant", newServer),
+			idArg:   "tenant",
+		},
+	}
+}
+
+func (tm *tenantManager) exposeToJS(rootModule js.Value) {
+	rootModule.Set("tenants", make(map[string]interface{}))
+	tenantModule := rootModule.Get("tenants")
+
+	tenantModule.Set("addTenant", js.FuncOf(tm.AddTenant))
+	tenantModule.Set("addTenantFromSetup", js.FuncOf(tm.AddTenantFromSetup))
+	tenantModule.Set("addDerivedTenant", js.FuncOf(tm.AddDerivedTenant))
+	tenantModule.Set("initServer", js.FuncOf(tm.InitializeServer))
+	tm.exposeMethods(tenantModule)
+	tenantModule.Set("removeTenant", js.FuncOf(tm.servers.JSDestroy))
+	tenantModule.Set("removeAll", js.FuncOf(tm.servers.JSDestroyAll))
+	tenantModule.Set("listTenants", js.FuncOf(tm.servers.JSList))
+	tenantModule.Set("setMaxTenants", js.FuncOf(tm.servers.JSSetMaxInstances))
+}
+
+// addTenant(tenant: string, suiteName: string, serverID: string, privKey: Uint8Array | null, oprfSeed?: Uint8Array | boolean) Promise<void>
+// Registers a tenant with a server initialized like initServer. The name of a removed tenant can be used again.
+func (tm *tenantManager) AddTenant(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		tenant, err := tenantName(inputs, 4, 5)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		sv := newServer()
+
+		if err := initServerFromInputs(sv, inputs); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := tm.addServer(tenant, sv); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke()
+	}
+
+	return promiser(runner)
+}
+
+// addTenantFromSetup(tenant: string, setup: Uint8Array) Promise<Uint8Array | null>
+// Registers a tenant with a server initialized from a setup blob and resolves with the stored oprf seed,
+// or with null when the blob has none.
+func (tm *tenantManager) AddTenantFromSetup(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		tenant, err := tenantName(inputs, 2, 2)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		setup, err := copyBytesToGo(inputs[1], "setup")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		sv := newServer()
+
+		oprfSeed, err := sv.ImportSetup(setup)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := tm.addServer(tenant, sv); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if oprfSeed == nil {
+			resolve.Invoke(js.Null())
+			return
+		}
+
+		dataJS := copyBytesToJS(oprfSeed)
+		resolve.Invoke(dataJS)
+	}
+
+	return promiser(runner)
+}
+
+// addDerivedTenant(tenant: string, suiteName: string, serverID: string, masterSecret: Uint8Array) Promise<Uint8Array>
+// Registers a tenant whose oprf seed and server private key are derived from the master secret and the tenant name,
+// see deriveTenantSecrets. The seed is bound to the server. Resolves with the public key of the tenant.
+func (tm *tenantManager) AddDerivedTenant(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		tenant, err := tenantName(inputs, 4, 4)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenSuite := inputs[1]
+		chosenServerID := inputs[2]
+
+		if err := checkIsString(chosenSuite, "suiteName"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsString(chosenServerID, "serverID"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		suiteID, err := strToSuite(chosenSuite.String())
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		masterSecret, err := copyBytesToGo(inputs[3], "masterSecret")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+		defer wipeBytes(masterSecret)
+
+		secrets, err := deriveTenantSecrets(suiteID, masterSecret, tenant)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		sv := newServer()
+
+		if err := sv.InitializeServer(chosenSuite.String(), chosenServerID.String(), secrets.privKey, secrets.oprfSeed, false); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := tm.addServer(tenant, sv); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		dataJS := copyBytesToJS(secrets.pubKey)
+		resolve.Invoke(dataJS)
+	}
+
+	return promiser(runner)
+}
+
+// addServer registers sv under tenant, or wipes it when the tenant can not be added.
+func (tm *tenantManager) addServer(tenant string, sv *server) error {
+	if err := tm.servers.add(tenant, sv, "tenant"); err != nil {
+		sv.destroy()
+		return err
+	}
+	return nil
+}
+
+// tenantName checks the input length and returns the tenant name given as first input.
+func tenantName(inputs []js.Value, minLen, maxLen int) (string, error) {
+	if err := checkInputLenBetween(inputs, minLen, maxLen); err != nil {
+		return "", err
+	}
+
+	if err := checkIsString(inputs[0], "tenant"); err != nil {
+		return "", err
+	}
+
+	tenant := inputs[0].String()
+	if err := checkTenantName(tenant); err != nil {
+		return "", err
+	}
+
+	return tenant, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/cymony/cryptomony/opaque"
+	"github.com/cymony/cryptomony/utils"
+)
+
+const (
+	minMasterSecretLen = 32  // shortest master secret accepted for tenant derivation
+	maxTenantNameLen   = 255 // longest tenant name
+)
+
+var (
+	labelTenantSecrets  = "cryptomonyjs-opaque TenantSecrets"
+	labelTenantOprfSeed = "OprfSeed"
+	labelTenantAKEKey   = "ServerKey"
+)
+
+// tenantSecrets are the server secrets of one tenant derived from a master secret.
+type tenantSecrets struct {
+	oprfSeed []byte
+	privKey  []byte // encoded AKE private key
+	pubKey   []byte // encoded AKE public key
+}
+
+// deriveTenantSecrets derives the oprf seed and the AKE key pair of the tenant from a master secret
+// with HKDF over the hash of the suite, so that only the master secret has to be stored:
+//
+//	prk      = Extract("cryptomonyjs-opaque TenantSecrets", masterSecret)
+//	info(x)  = concat(I2OSP(suite, 2), I2OSP(len(tenant), 2), tenant, x)
+//	oprfSeed = Expand(prk, info("OprfSeed"), Nh)
+//	akeSeed  = Expand(prk, info("ServerKey"), Nseed)
+//	privKey  = DeriveAuthKeyPair(akeSeed)
+//
+// The output only depends on these inputs, it must not change between releases.
+func deriveTenantSecrets(suiteID opaque.Identifier, masterSecret []byte, tenant string) (*tenantSecrets, error) {
+	if len(masterSecret) < minMasterSecretLen {
+		return nil, argError("masterSecret", "master secret must be at least %d bytes", minMasterSecretLen)
+	}
+
+	if err := checkTenantName(tenant); err != nil {
+		return nil, err
+	}
+
+	suite := newSuite(suiteID)
+
+	prefix := make([]byte, 4)
+	binary.BigEndian.PutUint16(prefix, uint16(suiteID))
+	binary.BigEndian.PutUint16(prefix[2:], uint16(len(tenant)))
+
+	info := func(label string) []byte {
+		return utils.Concat(prefix, []byte(tenant), []byte(label))
+	}
+
+	prk := suite.Extract([]byte(labelTenantSecrets), masterSecret)
+	defer wipeBytes(prk)
+
+	oprfSeed := suite.Expand(prk, info(labelTenantOprfSeed), suite.Nh())
+
+	akeSeed := suite.Expand(prk, info(labelTenantAKEKey), suite.Nseed())
+	defer wipeBytes(akeSeed)
+
+	privKey, err := suite.DeriveAuthKeyPair(akeSeed)
+	if err != nil {
+		return nil, err
+	}
+
+	encodedPrivKey, err := privKey.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	encodedPubKey, err := privKey.Public().MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tenantSecrets{oprfSeed: oprfSeed, privKey: encodedPrivKey, pubKey: encodedPubKey}, nil
+}
+
+func checkTenantName(tenant string) error {
+	if len(tenant) == 0 || len(tenant) > maxTenantNameLen {
+		return argError("tenant", "tenant name must be between 1 and %d bytes", maxTenantNameLen)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/cymony/cryptomony/opaque"
+)
+
+// tenantSecretsVectors pin the output of deriveTenantSecrets. Servers derive their keys from the
+// stored master secret on every start, so any change to the derivation would lock out every
+// registered user and must come with a new label instead.
+var tenantSecretsVectors = []struct {
+	suite        opaque.Identifier
+	masterSecret string
+	tenant       string
+	oprfSeed     string
+	privKey      string
+	pubKey       string
+}{
+	{
+		suite:        opaque.Ristretto255Suite,
+		masterSecret: "4242424242424242424242424242424242424242424242424242424242424242",
+		tenant:       "acme",
+		oprfSeed:     "b417ae5e0df9a43b081fad03c4a4e6abcc37cd40651530c029c3a9680a07a0a95bb0492b71dab7abf2fd2a1e5baa145faa59b58edef6617b6ef46451ec6efd93",
+		privKey:      "10467ee3298fef162648e9a7fcaf04ca984b396147247eda836460c4eecc0d0d",
+		pubKey:       "de87ec4cb9aa97bb1dcfa91913f2302b416d4ca2ed3bb8f76a6a7595d0afc658",
+	},
+	{
+		suite:        opaque.Ristretto255Suite,
+		masterSecret: "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122232425262728292a2b2c2d2e2f",
+		tenant:       "tenant-2",
+		oprfSeed:     "131bcbe14d961ee0be506d9953f61ebc8b244180a5d9ed327b2df428759ae069f6996987f74101494c019647a7cebd9bcc856860673c7468d6d55415e13d3b12",
+		privKey:      "162efc2dbc5373d93de16801f78f54715d2f50f7a971f55f481086f33f8bf500",
+		pubKey:       "66814b86ef469fb4215b58ba294394e6ec0d2f660fb7e453a797c6c07235d96a",
+	},
+	{
+		suite:        opaque.P256Suite,
+		masterSecret: "4242424242424242424242424242424242424242424242424242424242424242",
+		tenant:       "acme",
+		oprfSeed:     "5ca0cc7ea2b740e2e52131c3e56a251eb800112029875e51cea49a6234fd960f",
+		privKey:      "9bb28746945a0aa445004f3fb32e052159bc1fceb10886177ffc114c037b28fa",
+		pubKey:       "03c5d9d0039efa84d723849d1d7e7e294fa9e0261b632b3ef2a62c62b5be413a3d",
+	},
+	{
+		suite:        opaque.P256Suite,
+		masterSecret: "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122232425262728292a2b2c2d2e2f",
+		tenant:       "tenant-2",
+		oprfSeed:     "f89939b4f6538da5185b62dbaf0cdb16cc9f932da1f205b581b3c3415c982cfa",
+		privKey:      "b151d0143c11c3e5724ea6670f7edb6f0d278011e44bf9c3131bb7fe3253cc05",
+		pubKey:       "03658c2049710cc1c69d2d1aa81beaffa7bfe78bea114e6a5fa477996fc2c87963",
+	},
+	{
+		suite:        p384SuiteID,
+		masterSecret: "4242424242424242424242424242424242424242424242424242424242424242",
+		tenant:       "acme",
+		oprfSeed:     "e8e4af21f5912faaf15f5729981c1fb40aff584e8bbdea4fcf9908c23416642e38e78cc5c16213570550714f27efa222",
+		privKey:      "0554c8800783002dd5208ee75d67f265f94caf2100ea2563a31823b982ca7e62d565eff7ec5805be3c1f6c90d0722151",
+		pubKey:       "02239fec42139edaaa84799b64e6b2887eda01a50410a78bb162bcc867692ed6dcb33455aac550b4bcc55a2e0c7b58ca34",
+	},
+	{
+		suite:        p384SuiteID,
+		masterSecret: "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122232425262728292a2b2c2d2e2f",
+		tenant:       "tenant-2",
+		oprfSeed:     "e13bacb2179206ddd83e709efe89609ce8dbc7965722eacdf962f1daaa984b4383582a24e2709eee2ccefbe8d65c55a6",
+		privKey:      "10d009f2bdfacdb1908e5c60cd1518a4fe34dde4e209fa7a37c674c49ba2f4c161aed0204236116b95d6939d8e793fa0",
+		pubKey:       "020b7f7287ef9e3b2d7016f8a76988b0ade9db2415f71a3dfdae4bc3e0541a85d5868c3fbbbfd2f5ebd8f2078c8cc0c7fd",
+	},
+	{
+		suite:        p521SuiteID,
+		masterSecret: "4242424242424242424242424242424242424242424242424242424242424242",
+		tenant:       "acme",
+		oprfSeed:     "8de10052eeb158628bf9378bb232af7339c86d12c2870dc51d0569c6a7b8bbee32a665ff90e5eda6f95e3d7f4f2e9dfbe55929b989f3d5859df253e6c4d43f3a",
+		privKey:      "00b94983333f9f000f57d742e7e2f36190082ef7cba604304e4e4771341466cbb74bb19e7d34b8fde1b8682882740491cadb480f59e792cd23438718632e991fc2ff",
+		pubKey:       "020175b713a21365c91e70584646cc0e79bb8cf32a48ed5f234baf3f87230c200c235433c18a4d98319828e58ba1705f682cd185791b6d68879e7ee7e1946133233882",
+	},
+	{
+		suite:        p521SuiteID,
+		masterSecret: "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122232425262728292a2b2c2d2e2f",
+		tenant:       "tenant-2",
+		oprfSeed:     "f72ee21cdd78726a35c5bcf53626854b74457e282565ac6ac0845faf86f262bed4dfdf0cef760390afb1ec3aa02b2a8db90d60e02d786f8648126687abc83b8b",
+		privKey:      "018b9f02b7bd2183c773120f1588abe2d94811e5e8ec2a234793e14053fea35ac640aafec1ca9a26bfe13ec71d4a85d5023fffa6b4d02a45545b769f4fc06ff98735",
+		pubKey:       "03016bc02e040d55792bed6da6f63f6dba25e75e08adec9dc4e0aa0a2a22757f86ac3388778103ebdb1a7f4bb07b5c1e18fe1e09f54173189376ea96aca55e679cd72c",
+	},
+}
+
+// TestDeriveTenantSecretsVectors checks deriveTenantSecrets against the fixed vectors.
+func TestDeriveTenantSecretsVectors(t *testing.T) {
+	for _, vec := range tenantSecretsVectors {
+		masterSecret, err := hex.DecodeString(vec.masterSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		secrets, err := deriveTenantSecrets(vec.suite, masterSecret, vec.tenant)
+		if err != nil {
+			t.Fatalf("suite %d, tenant %q: %v", vec.suite, vec.tenant, err)
+		}
+
+		got := map[string][]byte{"oprfSeed": secrets.oprfSeed, "privKey": secrets.privKey, "pubKey": secrets.pubKey}
+		want := map[string]string{"oprfSeed": vec.oprfSeed, "privKey": vec.privKey, "pubKey": vec.pubKey}
+
+		for name, value := range got {
+			if hex.EncodeToString(value) != want[name] {
+				t.Errorf("suite %d, tenant %q: got %s %x, want %s", vec.suite, vec.tenant, name, value, want[name])
+			}
+		}
+	}
+}
+
+// TestDeriveTenantSecretsKeyPair checks that the derived key pair is accepted by a server
+// and that its public key is the one derived along with it.
+func TestDeriveTenantSecretsKeyPair(t *testing.T) {
+	for _, entry := range supportedSuites {
+		secrets, err := deriveTenantSecrets(entry.id, bytes.Repeat([]byte{0x42}, minMasterSecretLen), "acme")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sv := newServer()
+		if err := sv.InitializeServer(string(entry.name), "acme.example", secrets.privKey, secrets.oprfSeed, false); err != nil {
+			t.Fatalf("%s: %v", entry.name, err)
+		}
+
+		pubKey, err := sv.PublicKey("")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(pubKey, secrets.pubKey) {
+			t.Errorf("%s: server public key differs from the derived one", entry.name)
+		}
+	}
+}
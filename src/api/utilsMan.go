@@ -0,0 +1,263 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"syscall/js"
+)
+
+// exposeUtils exposes the functions that do not belong to a client or server instance.
+func exposeUtils(rootModule js.Value) {
+	rootModule.Set("utils", make(map[string]interface{}))
+	utilsModule := rootModule.Get("utils")
+
+	utilsModule.Set("inspect", js.FuncOf(Inspect))
+	utilsModule.Set("deriveKey", js.FuncOf(DeriveKey))
+	utilsModule.Set("sealVault", js.FuncOf(SealVault))
+	utilsModule.Set("openVault", js.FuncOf(OpenVault))
+	utilsModule.Set("getSupportedSuites", js.FuncOf(GetSupportedSuites))
+}
+
+// getSupportedSuites() Promise<string[]>
+// Resolves with the names of the suites accepted as suiteName.
+func GetSupportedSuites(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		if err := checkInputLen(inputs, 0); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		namesJS := make([]interface{}, len(supportedSuites))
+		for i, entry := range supportedSuites {
+			namesJS[i] = string(entry.name)
+		}
+
+		resolve.Invoke(namesJS)
+	}
+
+	return promiser(runner)
+}
+
+/*
+* inspect(suiteName: string, messageType: string, data: Uint8Array) Promise<{
+*	messageType: string,
+*	suiteName: string,
+*	length: number,
+*	valid: boolean,
+*	fields: {name: string, offset: number, length: number, value?: Uint8Array}[],
+*	error?: {field: string, message: string}}>
+* messageType is one of registrationRequest, registrationResponse, registrationRecord, ke1, ke2 or ke3.
+* Decodes the message field by field. A field that fails to decode is reported in error, not rejected.
+ */
+func Inspect(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		if err := checkInputLen(inputs, 3); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenSuiteName := inputs[0]
+		chosenMessageType := inputs[1]
+
+		if err := checkIsString(chosenSuiteName, "suiteName"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsString(chosenMessageType, "messageType"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		data, err := copyBytesToGo(inputs[2], "data")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		result, err := inspectMessage(chosenSuiteName.String(), chosenMessageType.String(), data)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		fieldsJS := make([]interface{}, len(result.fields))
+		for i, field := range result.fields {
+			fieldJS := make(map[string]interface{})
+			fieldJS["name"] = field.name
+			fieldJS["offset"] = field.offset
+			fieldJS["length"] = field.length
+			if field.value != nil {
+				fieldJS["value"] = copyBytesToJS(field.value)
+			}
+			fieldsJS[i] = fieldJS
+		}
+
+		returnObj := make(map[string]interface{})
+		returnObj["messageType"] = result.messageType
+		returnObj["suiteName"] = result.suiteName
+		returnObj["length"] = result.length
+		returnObj["valid"] = result.errField == ""
+		returnObj["fields"] = fieldsJS
+
+		if result.errField != "" {
+			returnObj["error"] = map[string]interface{}{"field": result.errField, "message": result.errMsg}
+		}
+
+		resolve.Invoke(returnObj)
+	}
+
+	return promiser(runner)
+}
+
+// deriveKey(suiteName: string, exportKey: Uint8Array, label: string, length: number) Promise<Uint8Array>
+// Derives a subkey for the application label from an export key with HKDF over the hash of the suite.
+// Different labels give independent keys.
+func DeriveKey(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		if err := checkInputLen(inputs, 4); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenSuiteName := inputs[0]
+		chosenLabel := inputs[2]
+		chosenLength := inputs[3]
+
+		if err := checkIsString(chosenSuiteName, "suiteName"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		exportKey, err := copyBytesToGo(inputs[1], "exportKey")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+		defer wipeBytes(exportKey)
+
+		if err := checkIsString(chosenLabel, "label"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		if err := checkIsNumber(chosenLength, "length"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		suiteID, err := strToSuite(chosenSuiteName.String())
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		key, err := deriveKey(newSuite(suiteID), exportKey, chosenLabel.String(), chosenLength.Int())
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+		defer wipeBytes(key)
+
+		resolve.Invoke(copyBytesToJS(key))
+	}
+
+	return promiser(runner)
+}
+
+// sealVault(suiteName: string, exportKey: Uint8Array, clientIdentity: string, plaintext: Uint8Array) Promise<Uint8Array>
+// Encrypts plaintext into a versioned vault blob with a key derived from the export key.
+// The blob only opens with the same export key and client identity.
+func SealVault(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		if err := checkInputLen(inputs, 4); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenSuiteName := inputs[0]
+		chosenClientIdentity := inputs[2]
+
+		if err := checkIsString(chosenSuiteName, "suiteName"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		exportKey, err := copyBytesToGo(inputs[1], "exportKey")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+		defer wipeBytes(exportKey)
+
+		if err := checkIsString(chosenClientIdentity, "clientIdentity"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		plaintext, err := copyBytesToGo(inputs[3], "plaintext")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+		defer wipeBytes(plaintext)
+
+		suiteID, err := strToSuite(chosenSuiteName.String())
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		blob, err := sealVault(suiteID, exportKey, chosenClientIdentity.String(), plaintext)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		resolve.Invoke(copyBytesToJS(blob))
+	}
+
+	return promiser(runner)
+}
+
+// openVault(exportKey: Uint8Array, clientIdentity: string, blob: Uint8Array) Promise<Uint8Array>
+// Decrypts a vault blob created by sealVault. The suite is read from the blob.
+func OpenVault(this js.Value, inputs []js.Value) any {
+	runner := func(resolve js.Value, reject js.Value) {
+		if err := checkInputLen(inputs, 3); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		chosenClientIdentity := inputs[1]
+
+		exportKey, err := copyBytesToGo(inputs[0], "exportKey")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+		defer wipeBytes(exportKey)
+
+		if err := checkIsString(chosenClientIdentity, "clientIdentity"); err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		blob, err := copyBytesToGo(inputs[2], "blob")
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+
+		plaintext, err := openVault(exportKey, chosenClientIdentity.String(), blob)
+		if err != nil {
+			rejectErr(reject, err)
+			return
+		}
+		defer wipeBytes(plaintext)
+
+		resolve.Invoke(copyBytesToJS(plaintext))
+	}
+
+	return promiser(runner)
+}
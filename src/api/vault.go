@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/cymony/cryptomony/opaque"
+	"github.com/cymony/cryptomony/utils"
+)
+
+// Vault blob layout:
+//
+//	magic[4] = "COSV"
+//	version[1]
+//	suite[2]
+//	nonce[12]
+//	ciphertext
+//
+// The key is Expand(Extract("cryptomonyjs-opaque Vault", exportKey), "Key", 32) and the additional
+// data is concat(header, I2OSP(len(clientIdentity), 2), clientIdentity), where the header is
+// everything before the nonce. Integers are big-endian, so a blob sealed by one build opens in every other.
+const (
+	vaultMagic     = "COSV"
+	vaultVersion   = 1
+	vaultHeaderLen = len(vaultMagic) + 1 + 2
+	vaultKeyLen    = 32
+)
+
+var (
+	labelVault      = "cryptomonyjs-opaque Vault"
+	labelVaultKey   = "Key"
+	errInvalidVault = &apiError{code: codeDecode, argName: "blob", msg: "invalid vault blob"}
+	errVaultAuth    = &apiError{code: codeAuthFailed, argName: "blob", msg: "vault can not be opened with this export key and client identity"}
+)
+
+// vaultAEAD returns the AEAD keyed for the vaults of exportKey.
+func vaultAEAD(suite opaque.Suite, exportKey []byte) (cipher.AEAD, error) {
+	key, err := vaultKey(suite, exportKey)
+	if err != nil {
+		return nil, err
+	}
+	defer wipeBytes(key)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// vaultKey derives the vault key of exportKey. Unlike the keys of deriveKey it is extracted
+// with its own salt, so no application label reaches it.
+func vaultKey(suite opaque.Suite, exportKey []byte) ([]byte, error) {
+	if len(exportKey) != suite.Nh() {
+		return nil, argError("exportKey", "export key must be %d bytes", suite.Nh())
+	}
+
+	prk := suite.Extract([]byte(labelVault), exportKey)
+	defer wipeBytes(prk)
+
+	return suite.Expand(prk, []byte(labelVaultKey), vaultKeyLen), nil
+}
+
+func vaultAD(header []byte, clientIdentity string) ([]byte, error) {
+	ad := bytes.NewBuffer(append([]byte(nil), header...))
+	if err := writeVector(ad, []byte(clientIdentity)); err != nil {
+		return nil, argError("clientIdentity", "client identity is too long")
+	}
+
+	return ad.Bytes(), nil
+}
+
+// sealVault encrypts plaintext into a vault blob that only opens with the same export key and client identity.
+func sealVault(suiteID opaque.Identifier, exportKey []byte, clientIdentity string, plaintext []byte) ([]byte, error) {
+	aead, err := vaultAEAD(newSuite(suiteID), exportKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := &bytes.Buffer{}
+	header.WriteString(vaultMagic)
+	header.WriteByte(vaultVersion)
+
+	if err := binary.Write(header, binary.BigEndian, uint16(suiteID)); err != nil {
+		return nil, err
+	}
+
+	ad, err := vaultAD(header.Bytes(), clientIdentity)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := utils.RandomBytes(aead.NonceSize())
+
+	return utils.Concat(header.Bytes(), nonce, aead.Seal(nil, nonce, plaintext, ad)), nil
+}
+
+// openVault decrypts a vault blob created by sealVault. The suite is read from the blob.
+// The caller should wipe the returned plaintext.
+func openVault(exportKey []byte, clientIdentity string, blob []byte) ([]byte, error) {
+	if len(blob) < vaultHeaderLen {
+		return nil, fmt.Errorf("%w: truncated data", errInvalidVault)
+	}
+
+	header := blob[:vaultHeaderLen]
+	if string(header[:len(vaultMagic)]) != vaultMagic {
+		return nil, fmt.Errorf("%w: unknown format", errInvalidVault)
+	}
+
+	if header[4] != vaultVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", errInvalidVault, header[4])
+	}
+
+	suiteID := opaque.Identifier(binary.BigEndian.Uint16(header[5:]))
+	if err := checkSuiteID(suiteID); err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidVault, err)
+	}
+
+	aead, err := vaultAEAD(newSuite(suiteID), exportKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceLen := aead.NonceSize()
+	if len(blob) < vaultHeaderLen+nonceLen+aead.Overhead() {
+		return nil, fmt.Errorf("%w: truncated data", errInvalidVault)
+	}
+
+	ad, err := vaultAD(header, clientIdentity)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, blob[vaultHeaderLen:vaultHeaderLen+nonceLen], blob[vaultHeaderLen+nonceLen:], ad)
+	if err != nil {
+		return nil, errVaultAuth
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestVault seals a vault and opens it as sealed, tampered and under another export key
+// or client identity.
+func TestVault(t *testing.T) {
+	suiteID, err := strToSuite("Ristretto255Suite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nh := newSuite(suiteID).Nh()
+	exportKey := bytes.Repeat([]byte{1}, nh)
+	plaintext := []byte("recovery codes")
+
+	blob, err := sealVault(suiteID, exportKey, "alice", plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flip := func(i int) []byte {
+		tampered := append([]byte(nil), blob...)
+		tampered[i] ^= 1
+		return tampered
+	}
+
+	tests := []struct {
+		name           string
+		exportKey      []byte
+		clientIdentity string
+		blob           []byte
+		wantCode       errorCode
+	}{
+		{name: "round trip", exportKey: exportKey, clientIdentity: "alice", blob: blob},
+		{name: "wrong identity", exportKey: exportKey, clientIdentity: "bob", blob: blob, wantCode: codeAuthFailed},
+		{name: "empty identity", exportKey: exportKey, clientIdentity: "", blob: blob, wantCode: codeAuthFailed},
+		{name: "wrong export key", exportKey: bytes.Repeat([]byte{2}, nh), clientIdentity: "alice", blob: blob, wantCode: codeAuthFailed},
+		{name: "short export key", exportKey: exportKey[1:], clientIdentity: "alice", blob: blob, wantCode: codeInvalidArgument},
+		{name: "tampered ciphertext", exportKey: exportKey, clientIdentity: "alice", blob: flip(len(blob) - 1), wantCode: codeAuthFailed},
+		{name: "tampered nonce", exportKey: exportKey, clientIdentity: "alice", blob: flip(vaultHeaderLen), wantCode: codeAuthFailed},
+		{name: "tampered magic", exportKey: exportKey, clientIdentity: "alice", blob: flip(0), wantCode: codeDecode},
+		{name: "tampered version", exportKey: exportKey, clientIdentity: "alice", blob: flip(4), wantCode: codeDecode},
+		{name: "tampered suite", exportKey: exportKey, clientIdentity: "alice", blob: flip(6), wantCode: codeDecode},
+		{name: "truncated", exportKey: exportKey, clientIdentity: "alice", blob: blob[:vaultHeaderLen+1], wantCode: codeDecode},
+	}
+
+	for _, tt := range tests {
+		got, err := openVault(tt.exportKey, tt.clientIdentity, tt.blob)
+		if errorCodeOf(err) != tt.wantCode {
+			t.Errorf("%s: got %v, want code %q", tt.name, err, tt.wantCode)
+			continue
+		}
+
+		if err == nil && !bytes.Equal(got, plaintext) {
+			t.Errorf("%s: got plaintext %q, want %q", tt.name, got, plaintext)
+		}
+	}
+}
+
+// TestVaultKeyUnreachable checks that deriveKey refuses the labels of the vault key.
+func TestVaultKeyUnreachable(t *testing.T) {
+	suiteID, err := strToSuite("Ristretto255Suite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suite := newSuite(suiteID)
+	exportKey := bytes.Repeat([]byte{1}, suite.Nh())
+
+	for _, label := range []string{labelVault, labelPrefix, labelPrefix + " Other"} {
+		if _, err := deriveKey(suite, exportKey, label, vaultKeyLen); errorCodeOf(err) != codeInvalidArgument {
+			t.Errorf("%q: got %v, want code %q", label, err, codeInvalidArgument)
+		}
+	}
+
+	if _, err := deriveKey(suite, exportKey, "app "+labelVault, vaultKeyLen); err != nil {
+		t.Errorf("label containing the prefix: %v", err)
+	}
+}
@@ -1,4 +1,7 @@
 export * from "./modules/wasm";
 export * from './modules/client';
 export * from "./modules/server";
-
+export * from "./modules/tenants";
+export * from "./modules/channel";
+export * from "./modules/errors";
+export * from "./modules/utils";
@@ -0,0 +1,83 @@
+import { getWasmChannel, Suite } from '../consts'
+
+export type ChannelRole = 'client' | 'server'
+
+export interface ChannelConfiguration {
+    suiteName: Suite
+    sessionKey: Uint8Array
+    role: ChannelRole
+}
+
+export class SecureChannel {
+    private _identifier: string = '';
+
+    constructor() {
+        const wasmCh = getWasmChannel();
+        let chid = wasmCh.newChannel();
+        if (chid instanceof Error) {
+            throw chid;
+        }
+        this._identifier = chid;
+    }
+
+    private get identifier(): string {
+        return this._identifier;
+    }
+
+    /**
+    * initChannel derives one key per direction from the session key of a finished login.
+    * The client and the server end of the channel must use different roles.
+    */
+    initChannel(conf: ChannelConfiguration): Promise<void> {
+        const wasmCh = getWasmChannel();
+        return wasmCh.initChannel(this.identifier, conf.suiteName, conf.sessionKey, conf.role);
+    }
+
+    /**
+    * seal encrypts the plaintext into the next message for the peer.
+    * @param ad additional data authenticated but not encrypted, the peer must open with the same ad
+    */
+    seal(plaintext: Uint8Array, ad: Uint8Array | null = null): Promise<Uint8Array> {
+        const wasmCh = getWasmChannel();
+        return wasmCh.seal(this.identifier, plaintext, ad);
+    }
+
+    /**
+    * open decrypts the next message from the peer. Messages must be opened in the order they were sealed,
+    * replayed, reordered and dropped messages are rejected with ERR_OUT_OF_SEQUENCE.
+    */
+    open(message: Uint8Array, ad: Uint8Array | null = null): Promise<Uint8Array> {
+        const wasmCh = getWasmChannel();
+        return wasmCh.open(this.identifier, message, ad);
+    }
+
+    /**
+    * rekey moves the sending direction to a new key derived from the current one.
+    * The peer follows when it opens the next message. Sealing fails with ERR_LIMIT_EXCEEDED
+    * after 2^32 messages without rekeying, and rekeying after 16 rekeys without sealing.
+    */
+    rekey(): Promise<void> {
+        const wasmCh = getWasmChannel();
+        return wasmCh.rekey(this.identifier);
+    }
+
+    destroy(): Promise<void> {
+        const wasmCh = getWasmChannel();
+        return wasmCh.destroyChannel(this.identifier);
+    }
+
+    static destroyAll(): Promise<void> {
+        const wasmCh = getWasmChannel();
+        return wasmCh.destroyAll();
+    }
+
+    static list(): Promise<string[]> {
+        const wasmCh = getWasmChannel();
+        return wasmCh.listChannels();
+    }
+
+    static setMaxInstances(max: number): Promise<void> {
+        const wasmCh = getWasmChannel();
+        return wasmCh.setMaxChannels(max);
+    }
+}
@@ -1,4 +1,4 @@
-import { getWasmClient, Suite } from '../consts'
+import { getWasmClient, Phase, Suite } from '../consts'
 
 export interface ClientConfiguration {
     suiteName: Suite
@@ -11,6 +11,9 @@ export class Client {
     constructor() {
         const wasmCl = getWasmClient();
         let clid = wasmCl.newClient();
+        if (clid instanceof Error) {
+            throw clid;
+        }
         this._identifier = clid;
     }
 
@@ -54,4 +57,102 @@ export class Client {
         const wasmCl = getWasmClient();
         return wasmCl.loginFinish(this.identifier, loginState, ke2, clientIdentity);
     }
+
+    /**
+    * loginInitSession is like loginInit but keeps the login state inside the module.
+    * Only the session handle is returned, to be passed to loginFinishSession.
+    */
+    loginInitSession(password: string): Promise<{ session: string, ke1: Uint8Array }> {
+        const wasmCl = getWasmClient();
+        return wasmCl.loginInitSession(this.identifier, password);
+    }
+
+    /**
+    * loginFinishSession finishes the login of the session handle. The login state is wiped
+    * whether or not the login succeeds, and sessions expire after five minutes.
+    */
+    loginFinishSession(session: string, ke2: Uint8Array, clientIdentity: string): Promise<{
+        ke3: Uint8Array
+        sessionKey: Uint8Array
+        exportKey: Uint8Array
+    }> {
+        const wasmCl = getWasmClient();
+        return wasmCl.loginFinishSession(this.identifier, session, ke2, clientIdentity);
+    }
+
+    /**
+    * changePasswordInit starts a password change: a login with the old password and a registration
+    * of the new one. Send ke1 and registrationRequest to the server's changePasswordInit.
+    */
+    changePasswordInit(oldPassword: string, newPassword: string): Promise<{
+        session: string
+        ke1: Uint8Array
+        registrationRequest: Uint8Array
+    }> {
+        const wasmCl = getWasmClient();
+        return wasmCl.changePasswordInit(this.identifier, oldPassword, newPassword);
+    }
+
+    /**
+    * changePasswordFinish authenticates with the old password, registers the new one and seals
+    * the given vault blobs again with the new export key. Send ke3 and registrationRecord
+    * to the server's changePasswordFinish.
+    */
+    changePasswordFinish(session: string, ke2: Uint8Array, registrationResponse: Uint8Array, clientIdentity: string, vaultBlobs: Uint8Array[] = []): Promise<{
+        ke3: Uint8Array
+        registrationRecord: Uint8Array
+        sessionKey: Uint8Array
+        oldExportKey: Uint8Array
+        newExportKey: Uint8Array
+        vaultBlobs: Uint8Array[]
+    }> {
+        const wasmCl = getWasmClient();
+        return wasmCl.changePasswordFinish(this.identifier, session, ke2, registrationResponse, clientIdentity, vaultBlobs);
+    }
+
+    /**
+    * enableStateSealing encrypts and authenticates the registration and login states returned by this instance
+    * with a key held inside the module. Sealed states expire after five minutes.
+    * @param key 32 byte key, or null to generate one
+    * @returns Promise<Uint8Array> the key in use
+    */
+    enableStateSealing(key: Uint8Array | null = null): Promise<Uint8Array> {
+        const wasmCl = getWasmClient();
+        return wasmCl.enableStateSealing(this.identifier, key);
+    }
+
+    disableStateSealing(): Promise<void> {
+        const wasmCl = getWasmClient();
+        return wasmCl.disableStateSealing(this.identifier);
+    }
+
+    setStrictMode(enabled: boolean): Promise<void> {
+        const wasmCl = getWasmClient();
+        return wasmCl.setStrictMode(this.identifier, enabled);
+    }
+
+    getPhase(): Promise<Phase> {
+        const wasmCl = getWasmClient();
+        return wasmCl.getPhase(this.identifier);
+    }
+
+    destroy(): Promise<void> {
+        const wasmCl = getWasmClient();
+        return wasmCl.destroyClient(this.identifier);
+    }
+
+    static destroyAll(): Promise<void> {
+        const wasmCl = getWasmClient();
+        return wasmCl.destroyAll();
+    }
+
+    static list(): Promise<string[]> {
+        const wasmCl = getWasmClient();
+        return wasmCl.listClients();
+    }
+
+    static setMaxInstances(max: number): Promise<void> {
+        const wasmCl = getWasmClient();
+        return wasmCl.setMaxClients(max);
+    }
 }
@@ -1,8 +1,13 @@
 const wasmRootEl: string = "__cryptomonyjsopaque__";
 const clientRootEl: string = "client";
 const serverRootEl: string = "server";
+const utilsRootEl: string = "utils";
+const channelRootEl: string = "channel";
+const tenantsRootEl: string = "tenants";
 
-export type Suite = 'Ristretto255Suite' | 'P256Suite'
+export type Suite = 'Ristretto255Suite' | 'P256Suite' | 'P384Suite' | 'P521Suite'
+
+export type Phase = 'idle' | 'registration' | 'login' | 'busy'
 
 export const isNode = typeof process !== "undefined" && process.versions != null &&
     process.versions.node != null;
@@ -14,3 +19,15 @@ export const getWasmClient = () => {
 export const getWasmServer = () => {
     return globalThis[wasmRootEl][serverRootEl]
 }
+
+export const getWasmUtils = () => {
+    return globalThis[wasmRootEl][utilsRootEl]
+}
+
+export const getWasmChannel = () => {
+    return globalThis[wasmRootEl][channelRootEl]
+}
+
+export const getWasmTenants = () => {
+    return globalThis[wasmRootEl][tenantsRootEl]
+}
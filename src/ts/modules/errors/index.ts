@@ -0,0 +1,27 @@
+export const ErrorCodes = {
+    NotInitialized: 'ERR_NOT_INITIALIZED',
+    InvalidArgument: 'ERR_INVALID_ARGUMENT',
+    AuthFailed: 'ERR_AUTH_FAILED',
+    Decode: 'ERR_DECODE',
+    NotFound: 'ERR_NOT_FOUND',
+    InstanceDestroyed: 'ERR_INSTANCE_DESTROYED',
+    LimitExceeded: 'ERR_LIMIT_EXCEEDED',
+    OutOfSequence: 'ERR_OUT_OF_SEQUENCE',
+    Expired: 'ERR_EXPIRED',
+    Timeout: 'ERR_TIMEOUT',
+    Locked: 'ERR_LOCKED',
+    Internal: 'ERR_INTERNAL',
+} as const
+
+export type ErrorCode = typeof ErrorCodes[keyof typeof ErrorCodes]
+
+// OpaqueError is the shape of every error the library rejects with.
+export interface OpaqueError extends Error {
+    code: ErrorCode
+    // argument is the name of the offending argument, where relevant
+    argument?: string
+}
+
+export const isOpaqueError = (err: unknown): err is OpaqueError => {
+    return err instanceof Error && typeof (err as OpaqueError).code === 'string';
+}
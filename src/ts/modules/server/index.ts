@@ -1,31 +1,56 @@
-import { getWasmServer, Suite } from '../consts'
+import { getWasmServer, Phase, Suite } from '../consts'
+
+// RecordStore keeps registration records by credential ID, e.g. in a database.
+export interface RecordStore {
+    get(credID: string): Uint8Array | null | Promise<Uint8Array | null>
+    put(credID: string, record: Uint8Array): void | Promise<void>
+    delete(credID: string): void | Promise<void>
+}
+
+export interface LockoutPolicy {
+    // failed logins until the credential ID is locked, 0 means never locked
+    maxFailures: number
+    // wait after the n-th failed login, the last value repeats
+    backoffMs?: number[]
+    // lockout duration, 0 means until resetLockout
+    lockoutMs?: number
+}
 
 export interface ServerConfiguration {
     suiteName: Suite
     serverID: string
     privateKey: Uint8Array | null
+    // oprfSeed is bound to the server and used when no seed is given per call
+    oprfSeed?: Uint8Array | null
+    // generateOprfSeed generates and binds a new oprf seed when oprfSeed is not given
+    generateOprfSeed?: boolean
 }
 
-export class Server {
-    private _identifier: string = "";
+export interface ServerStats {
+    registrations: number
+    // KE2 messages returned, unknown users included
+    loginsStarted: number
+    loginsSucceeded: number
+    // KE3 messages refused for a known login state
+    loginsFailed: number
+    // logins refused by the lockout policy
+    lockouts: number
+}
 
-    constructor() {
-        const wasmSv = getWasmServer();
-        let svID = wasmSv.newServer();
-        this._identifier = svID;
-    }
+// ServerInstance holds the methods shared by servers and tenants.
+export abstract class ServerInstance {
+    protected abstract get identifier(): string
 
-    private get identifier(): string {
-        return this._identifier;
-    }
+    protected abstract get wasm(): any
 
     initServer(conf: ServerConfiguration): Promise<void> {
-        const wasmSv = getWasmServer();
-        return wasmSv.initServer(this.identifier, conf.suiteName, conf.serverID, conf.privateKey);
+        const wasmSv = this.wasm;
+        const oprfSeed = conf.oprfSeed ?? (conf.generateOprfSeed === true ? true : null);
+        return wasmSv.initServer(this.identifier, conf.suiteName, conf.serverID, conf.privateKey, oprfSeed);
     }
 
     isInitialized(): Promise<boolean> {
-        const wasmSv = getWasmServer();
+        const wasmSv = this.wasm;
         return wasmSv.isInitialized(this.identifier);
     }
 
@@ -34,25 +59,406 @@ export class Server {
     * @returns Promise<Uint8array>
     */
     generateOprfSeed(): Promise<Uint8Array> {
-        const wasmSv = getWasmServer();
+        const wasmSv = this.wasm;
         return wasmSv.generateOprfSeed(this.identifier);
     }
 
-    registrationEval(registrationRequest: Uint8Array, oprfSeed: Uint8Array, credentialIdentifier: string): Promise<Uint8Array> {
-        const wasmSv = getWasmServer();
+    registrationEval(registrationRequest: Uint8Array, oprfSeed: Uint8Array | null, credentialIdentifier: string): Promise<Uint8Array> {
+        const wasmSv = this.wasm;
         return wasmSv.registrationEval(this.identifier, registrationRequest, oprfSeed, credentialIdentifier);
     }
 
-    loginInit(record: Uint8Array, ke1: Uint8Array, oprfSeed: Uint8Array, credID: string, clientIdentity: string): Promise<{
+    /**
+    * loginInit answers a KE1 with the server key the record was registered under.
+    * @param serverKeyID the key ID of that server key, the current key when omitted
+    * @returns the server key ID used alongside the login state and KE2
+    */
+    loginInit(record: Uint8Array, ke1: Uint8Array, oprfSeed: Uint8Array | null, credID: string, clientIdentity: string, serverKeyID?: string): Promise<{
         loginState: Uint8Array
         ke2: Uint8Array
+        serverKeyID: string
     }> {
-        const wasmSv = getWasmServer();
-        return wasmSv.loginInit(this.identifier, record, ke1, oprfSeed, credID, clientIdentity);
+        const wasmSv = this.wasm;
+        return wasmSv.loginInit(this.identifier, record, ke1, oprfSeed, credID, clientIdentity, serverKeyID ?? null);
+    }
+
+    /**
+    * loginInitUnknownUser answers a KE1 for a credential ID without a registration record,
+    * so that unknown users can not be told apart from registered ones. loginFinish always fails for the returned state.
+    */
+    loginInitUnknownUser(ke1: Uint8Array, oprfSeed: Uint8Array | null, credID: string, clientIdentity: string): Promise<{
+        loginState: Uint8Array
+        ke2: Uint8Array
+    }> {
+        const wasmSv = this.wasm;
+        return wasmSv.loginInitUnknownUser(this.identifier, ke1, oprfSeed, credID, clientIdentity);
     }
 
     loginFinish(loginState: Uint8Array, ke3: Uint8Array): Promise<Uint8Array> {
-        const wasmSv = getWasmServer();
+        const wasmSv = this.wasm;
         return wasmSv.loginFinish(this.identifier, loginState, ke3);
     }
+
+    /**
+    * loginInitSession is like loginInit but keeps the login state inside the module.
+    * Only the session handle is returned, to be passed to loginFinishSession.
+    * @param serverKeyID the key ID of the server key the record was registered under, the current key when omitted
+    */
+    loginInitSession(record: Uint8Array, ke1: Uint8Array, oprfSeed: Uint8Array | null, credID: string, clientIdentity: string, serverKeyID?: string): Promise<{
+        session: string
+        ke2: Uint8Array
+    }> {
+        const wasmSv = this.wasm;
+        return wasmSv.loginInitSession(this.identifier, record, ke1, oprfSeed, credID, clientIdentity, serverKeyID ?? null);
+    }
+
+    loginInitUnknownUserSession(ke1: Uint8Array, oprfSeed: Uint8Array | null, credID: string, clientIdentity: string): Promise<{
+        session: string
+        ke2: Uint8Array
+    }> {
+        const wasmSv = this.wasm;
+        return wasmSv.loginInitUnknownUserSession(this.identifier, ke1, oprfSeed, credID, clientIdentity);
+    }
+
+    /**
+    * loginFinishSession finishes the login of the session handle. The login state is wiped
+    * whether or not the login succeeds, and sessions expire after five minutes.
+    */
+    loginFinishSession(session: string, ke3: Uint8Array): Promise<Uint8Array> {
+        const wasmSv = this.wasm;
+        return wasmSv.loginFinishSession(this.identifier, session, ke3);
+    }
+
+    /**
+    * getServerPublicKey returns the public key of the server key of serverKeyID, or of the current key.
+    */
+    getServerPublicKey(serverKeyID?: string): Promise<Uint8Array> {
+        const wasmSv = this.wasm;
+        return wasmSv.getServerPublicKey(this.identifier, serverKeyID ?? null);
+    }
+
+    /**
+    * rotateServerKey adds a server key, or a new random one when privKey is null, and makes it the current key.
+    * Previous keys stay usable through the serverKeyID of the loginInit functions until they are retired.
+    * @returns Promise<Uint8Array> the public key of the new key
+    */
+    rotateServerKey(serverKeyID: string, privKey: Uint8Array | null = null): Promise<Uint8Array> {
+        const wasmSv = this.wasm;
+        return wasmSv.rotateServerKey(this.identifier, serverKeyID, privKey);
+    }
+
+    /**
+    * retireServerKey wipes a previous server key. Records registered under it can not log in anymore.
+    */
+    retireServerKey(serverKeyID: string): Promise<void> {
+        const wasmSv = this.wasm;
+        return wasmSv.retireServerKey(this.identifier, serverKeyID);
+    }
+
+    listServerKeys(): Promise<{ serverKeyIDs: string[], current: string }> {
+        const wasmSv = this.wasm;
+        return wasmSv.listServerKeys(this.identifier);
+    }
+
+    /**
+    * exportServerSetup serializes suite, server ID, every server key with its key ID, the oprf seed
+    * and the oprf seeds added with addOprfSeed into one blob.
+    * oprfSeed may be omitted when a seed is bound to the server, or when the server only uses
+    * the seeds added with addOprfSeed. The blob then has no seed to bind.
+    * @returns Promise<Uint8array>
+    */
+    exportServerSetup(oprfSeed?: Uint8Array): Promise<Uint8Array> {
+        const wasmSv = this.wasm;
+        return wasmSv.exportServerSetup(this.identifier, oprfSeed ?? null);
+    }
+
+    /**
+    * importServerSetup initializes the server from a blob created by exportServerSetup
+    * and binds the oprf seed stored in it to the server. The oprf seeds added with addOprfSeed
+    * are replaced by the ones stored in the blob.
+    * @returns Promise<Uint8array | null> the oprf seed stored in the blob, null when it has none
+    */
+    importServerSetup(setup: Uint8Array): Promise<Uint8Array | null> {
+        const wasmSv = this.wasm;
+        return wasmSv.importServerSetup(this.identifier, setup);
+    }
+
+    /**
+    * setRecordStore replaces the store used by registerUpload and loginInitByCredID.
+    * null restores an empty in-memory store, which is the default.
+    */
+    setRecordStore(store: RecordStore | null): Promise<void> {
+        const wasmSv = this.wasm;
+        return wasmSv.setRecordStore(this.identifier, store);
+    }
+
+    registerUpload(credID: string, record: Uint8Array): Promise<void> {
+        const wasmSv = this.wasm;
+        return wasmSv.registerUpload(this.identifier, credID, record);
+    }
+
+    deleteRecord(credID: string): Promise<void> {
+        const wasmSv = this.wasm;
+        return wasmSv.deleteRecord(this.identifier, credID);
+    }
+
+    /**
+    * loginInitByCredID is like loginInit but takes the record from the record store.
+    * Unknown credential IDs are answered like loginInitUnknownUser.
+    * oprfSeed may be omitted when a seed is bound to the server.
+    * @param serverKeyID the key ID of the server key the record was registered under, the current key when omitted
+    * @param keyID the key ID of the oprf seed the record was registered with, as returned by registrationEvalKeyed
+    */
+    loginInitByCredID(credID: string, ke1: Uint8Array, clientIdentity: string, oprfSeed?: Uint8Array | null, serverKeyID?: string | null, keyID?: string): Promise<{
+        loginState: Uint8Array
+        ke2: Uint8Array
+    }> {
+        const wasmSv = this.wasm;
+        return wasmSv.loginInitByCredID(this.identifier, credID, ke1, clientIdentity, oprfSeed ?? null, serverKeyID ?? null, keyID ?? null);
+    }
+
+    /**
+    * changePasswordInit answers the login with the old password, taking the record from the record store,
+    * and evaluates the registration request of the new password. The login state is kept inside the module.
+    * The new record is registered under the current server key.
+    * @param serverKeyID the key ID of the server key the current record was registered under, the current key when omitted
+    * @param keyID the key ID of the oprf seed the current record was registered with. The new record is then
+    * registered with the active seed, and keyID in the result is the key ID to store with it.
+    */
+    changePasswordInit(credID: string, ke1: Uint8Array, registrationRequest: Uint8Array, clientIdentity: string, oprfSeed?: Uint8Array | null, serverKeyID?: string | null, keyID?: string): Promise<{
+        session: string
+        ke2: Uint8Array
+        registrationResponse: Uint8Array
+        keyID: string | null
+    }> {
+        const wasmSv = this.wasm;
+        return wasmSv.changePasswordInit(this.identifier, credID, ke1, registrationRequest, clientIdentity, oprfSeed ?? null, serverKeyID ?? null, keyID ?? null);
+    }
+
+    /**
+    * changePasswordFinish verifies the KE3 of the login with the old password and only then replaces
+    * the record of the credential ID in the record store. The old record stays in place on any failure.
+    * @returns Promise<Uint8Array> the session key
+    */
+    changePasswordFinish(session: string, ke3: Uint8Array, record: Uint8Array): Promise<Uint8Array> {
+        const wasmSv = this.wasm;
+        return wasmSv.changePasswordFinish(this.identifier, session, ke3, record);
+    }
+
+    /**
+    * addOprfSeed adds an oprf seed under keyID, or a new random one when oprfSeed is null.
+    * The first seed added becomes the active one. Without a given or bound seed,
+    * registrationEval and loginInit use the active seed.
+    * @returns Promise<Uint8Array> the seed
+    */
+    addOprfSeed(keyID: string, oprfSeed: Uint8Array | null = null): Promise<Uint8Array> {
+        const wasmSv = this.wasm;
+        return wasmSv.addOprfSeed(this.identifier, keyID, oprfSeed);
+    }
+
+    setActiveOprfSeed(keyID: string): Promise<void> {
+        const wasmSv = this.wasm;
+        return wasmSv.setActiveOprfSeed(this.identifier, keyID);
+    }
+
+    /**
+    * removeOprfSeed wipes the seed of keyID. Records registered with it can not log in anymore.
+    * The active seed can not be removed.
+    */
+    removeOprfSeed(keyID: string): Promise<void> {
+        const wasmSv = this.wasm;
+        return wasmSv.removeOprfSeed(this.identifier, keyID);
+    }
+
+    listOprfSeeds(): Promise<{ keyIDs: string[], active: string | null }> {
+        const wasmSv = this.wasm;
+        return wasmSv.listOprfSeeds(this.identifier);
+    }
+
+    /**
+    * registrationEvalKeyed is like registrationEval but uses the active oprf seed.
+    * Store keyID alongside the record and pass it to loginInitKeyed. serverKeyID is the current
+    * server key the record is registered under.
+    */
+    registrationEvalKeyed(registrationRequest: Uint8Array, credentialIdentifier: string): Promise<{
+        registrationResponse: Uint8Array
+        keyID: string
+        serverKeyID: string
+    }> {
+        const wasmSv = this.wasm;
+        return wasmSv.registrationEvalKeyed(this.identifier, registrationRequest, credentialIdentifier);
+    }
+
+    /**
+    * loginInitKeyed is like loginInit but uses the oprf seed of keyID, the key ID
+    * returned by registrationEvalKeyed when the record was registered.
+    * @param serverKeyID the server key ID returned along with keyID, the current key when omitted
+    */
+    loginInitKeyed(record: Uint8Array, ke1: Uint8Array, keyID: string, credID: string, clientIdentity: string, serverKeyID?: string): Promise<{
+        loginState: Uint8Array
+        ke2: Uint8Array
+    }> {
+        const wasmSv = this.wasm;
+        return wasmSv.loginInitKeyed(this.identifier, record, ke1, keyID, credID, clientIdentity, serverKeyID ?? null);
+    }
+
+    /**
+    * setLoginTimeout makes loginFinish reject with ERR_TIMEOUT once timeoutMs has passed since loginInit.
+    * Zero removes the timeout. While a timeout is set, unsealed login states are only accepted
+    * by the server instance that created them.
+    */
+    setLoginTimeout(timeoutMs: number): Promise<void> {
+        const wasmSv = this.wasm;
+        return wasmSv.setLoginTimeout(this.identifier, timeoutMs);
+    }
+
+    /**
+    * setMaxSessions bounds the number of session handles kept for logins in flight.
+    * Expired sessions do not count. Defaults to 65536.
+    */
+    setMaxSessions(max: number): Promise<void> {
+        const wasmSv = this.wasm;
+        return wasmSv.setMaxSessions(this.identifier, max);
+    }
+
+    /**
+    * setClock replaces the clock of the server, e.g. in tests.
+    * @param now returns the milliseconds since the unix epoch, or null to restore the system clock
+    * The system clock is also used while now throws or returns anything but a finite number.
+    */
+    setClock(now: (() => number) | null): Promise<void> {
+        const wasmSv = this.wasm;
+        return wasmSv.setClock(this.identifier, now);
+    }
+
+    /**
+    * setLockoutPolicy counts failed logins per credential ID and refuses loginInit
+    * with ERR_LOCKED during backoff and lockout. Logins not finished before their state
+    * expires count as failed too. The failures of a credential ID that is not locked
+    * are forgotten a day after the last one. null turns the policy off.
+    */
+    setLockoutPolicy(policy: LockoutPolicy | null): Promise<void> {
+        const wasmSv = this.wasm;
+        return wasmSv.setLockoutPolicy(this.identifier, policy);
+    }
+
+    resetLockout(credID: string): Promise<void> {
+        const wasmSv = this.wasm;
+        return wasmSv.resetLockout(this.identifier, credID);
+    }
+
+    exportLockoutState(): Promise<Uint8Array> {
+        const wasmSv = this.wasm;
+        return wasmSv.exportLockoutState(this.identifier);
+    }
+
+    importLockoutState(state: Uint8Array): Promise<void> {
+        const wasmSv = this.wasm;
+        return wasmSv.importLockoutState(this.identifier, state);
+    }
+
+    /**
+    * enableStateSealing encrypts and authenticates the login states returned by this instance
+    * with a key held inside the module. Sealed states expire after five minutes.
+    * @param key 32 byte key, or null to generate one
+    * @returns Promise<Uint8Array> the key in use
+    */
+    enableStateSealing(key: Uint8Array | null = null): Promise<Uint8Array> {
+        const wasmSv = this.wasm;
+        return wasmSv.enableStateSealing(this.identifier, key);
+    }
+
+    disableStateSealing(): Promise<void> {
+        const wasmSv = this.wasm;
+        return wasmSv.disableStateSealing(this.identifier);
+    }
+
+    /**
+    * setStrictMode makes loginFinish reject with ERR_OUT_OF_SEQUENCE a login state that is not pending
+    * on this server: not handed out by it, expired or already finished. Logins are tracked one by one,
+    * so any number of them can run at once.
+    */
+    setStrictMode(enabled: boolean): Promise<void> {
+        const wasmSv = this.wasm;
+        return wasmSv.setStrictMode(this.identifier, enabled);
+    }
+
+    /**
+    * getPhase resolves with login while strict mode is on and a login is pending, and idle otherwise.
+    */
+    getPhase(): Promise<Phase> {
+        const wasmSv = this.wasm;
+        return wasmSv.getPhase(this.identifier);
+    }
+
+    /**
+    * getStats counts the protocol outcomes of the server since it was created.
+    */
+    getStats(): Promise<ServerStats> {
+        const wasmSv = this.wasm;
+        return wasmSv.getStats(this.identifier);
+    }
+}
+
+export class Server extends ServerInstance {
+    private _identifier: string = "";
+
+    constructor() {
+        super();
+        const wasmSv = getWasmServer();
+        let svID = wasmSv.newServer();
+        if (svID instanceof Error) {
+            throw svID;
+        }
+        this._identifier = svID;
+    }
+
+    protected get identifier(): string {
+        return this._identifier;
+    }
+
+    protected get wasm(): any {
+        return getWasmServer();
+    }
+
+    static generateServerKeyPair(suiteName: Suite): Promise<{
+        privateKey: Uint8Array
+        publicKey: Uint8Array
+    }> {
+        const wasmSv = getWasmServer();
+        return wasmSv.generateServerKeyPair(suiteName);
+    }
+
+    /**
+    * deriveTenantSecrets derives the oprf seed and server key pair of a tenant from a master secret
+    * of at least 32 bytes and the tenant name. The same inputs always give the same secrets.
+    */
+    static deriveTenantSecrets(suiteName: Suite, masterSecret: Uint8Array, tenant: string): Promise<{
+        oprfSeed: Uint8Array
+        privateKey: Uint8Array
+        publicKey: Uint8Array
+    }> {
+        const wasmSv = getWasmServer();
+        return wasmSv.deriveTenantSecrets(suiteName, masterSecret, tenant);
+    }
+
+    destroy(): Promise<void> {
+        const wasmSv = getWasmServer();
+        return wasmSv.destroyServer(this.identifier);
+    }
+
+    static destroyAll(): Promise<void> {
+        const wasmSv = getWasmServer();
+        return wasmSv.destroyAll();
+    }
+
+    static list(): Promise<string[]> {
+        const wasmSv = getWasmServer();
+        return wasmSv.listServers();
+    }
+
+    static setMaxInstances(max: number): Promise<void> {
+        const wasmSv = getWasmServer();
+        return wasmSv.setMaxServers(max);
+    }
 }
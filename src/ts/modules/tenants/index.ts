@@ -0,0 +1,92 @@
+import { getWasmTenants, Suite } from '../consts'
+import { ServerConfiguration, ServerInstance } from '../server'
+
+/**
+* Tenant is the server of one tenant, registered under the tenant name. Every tenant has its own
+* configuration, oprf seeds, server keys, record store, lockout counters and stats.
+*/
+export class Tenant extends ServerInstance {
+    private _name: string;
+
+    private constructor(name: string) {
+        super();
+        this._name = name;
+    }
+
+    protected get identifier(): string {
+        return this._name;
+    }
+
+    protected get wasm(): any {
+        return getWasmTenants();
+    }
+
+    get name(): string {
+        return this._name;
+    }
+
+    /**
+    * add registers a tenant with a server initialized from conf.
+    * The name of a removed tenant can be used again.
+    */
+    static async add(name: string, conf: ServerConfiguration): Promise<Tenant> {
+        const wasmTn = getWasmTenants();
+        const oprfSeed = conf.oprfSeed ?? (conf.generateOprfSeed === true ? true : null);
+        await wasmTn.addTenant(name, conf.suiteName, conf.serverID, conf.privateKey, oprfSeed);
+        return new Tenant(name);
+    }
+
+    /**
+    * addFromSetup registers a tenant with a server initialized from a blob created by exportServerSetup.
+    * @returns the tenant and the oprf seed stored in the blob, null when it has none
+    */
+    static async addFromSetup(name: string, setup: Uint8Array): Promise<{
+        tenant: Tenant
+        oprfSeed: Uint8Array | null
+    }> {
+        const wasmTn = getWasmTenants();
+        const oprfSeed = await wasmTn.addTenantFromSetup(name, setup);
+        return { tenant: new Tenant(name), oprfSeed };
+    }
+
+    /**
+    * addDerived registers a tenant whose oprf seed and server private key are derived from
+    * the master secret and the tenant name, see Server.deriveTenantSecrets. The seed is bound to the server.
+    * @returns the tenant and its public key
+    */
+    static async addDerived(name: string, suiteName: Suite, serverID: string, masterSecret: Uint8Array): Promise<{
+        tenant: Tenant
+        publicKey: Uint8Array
+    }> {
+        const wasmTn = getWasmTenants();
+        const publicKey = await wasmTn.addDerivedTenant(name, suiteName, serverID, masterSecret);
+        return { tenant: new Tenant(name), publicKey };
+    }
+
+    /**
+    * get returns the tenant registered under name. Calls fail with ERR_NOT_FOUND if there is none.
+    */
+    static get(name: string): Tenant {
+        return new Tenant(name);
+    }
+
+    remove(): Promise<void> {
+        const wasmTn = getWasmTenants();
+        return wasmTn.removeTenant(this.identifier);
+    }
+
+    static removeAll(): Promise<void> {
+        const wasmTn = getWasmTenants();
+        return wasmTn.removeAll();
+    }
+
+    static list(): Promise<string[]> {
+        const wasmTn = getWasmTenants();
+        return wasmTn.listTenants();
+    }
+
+    static setMaxInstances(max: number): Promise<void> {
+        const wasmTn = getWasmTenants();
+        return wasmTn.setMaxTenants(max);
+    }
+}
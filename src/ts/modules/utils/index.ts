@@ -0,0 +1,69 @@
+import { getWasmUtils, Suite } from '../consts'
+
+export type MessageType = 'registrationRequest' | 'registrationResponse' | 'registrationRecord' | 'ke1' | 'ke2' | 'ke3'
+
+export interface InspectedField {
+    name: string
+    offset: number
+    length: number
+    // value is omitted for secret fields such as the masking key
+    value?: Uint8Array
+}
+
+export interface Inspection {
+    messageType: MessageType
+    suiteName: Suite
+    length: number
+    valid: boolean
+    fields: InspectedField[]
+    // error names the first field that failed to decode
+    error?: { field: string, message: string }
+}
+
+/**
+* inspect decodes a protocol message field by field for debugging.
+* A field that fails to decode is reported in error instead of rejecting.
+*/
+export const inspect = (suiteName: Suite, messageType: MessageType, data: Uint8Array): Promise<Inspection> => {
+    const wasmUtils = getWasmUtils();
+    return wasmUtils.inspect(suiteName, messageType, data);
+}
+
+/**
+* deriveKey derives a subkey for the application label from an export key
+* with HKDF over the hash of the suite. Different labels give independent keys,
+* and no label gives the key of sealVault. Labels starting with "cryptomonyjs-opaque"
+* are reserved and rejected.
+* @param length length of the key in bytes
+*/
+export const deriveKey = (suiteName: Suite, exportKey: Uint8Array, label: string, length: number): Promise<Uint8Array> => {
+    const wasmUtils = getWasmUtils();
+    return wasmUtils.deriveKey(suiteName, exportKey, label, length);
+}
+
+/**
+* sealVault encrypts plaintext into a versioned vault blob with a key derived from the export key,
+* for example to keep user secrets on the server. The blob only opens with the same export key
+* and client identity, in browser and Node builds alike.
+*/
+export const sealVault = (suiteName: Suite, exportKey: Uint8Array, clientIdentity: string, plaintext: Uint8Array): Promise<Uint8Array> => {
+    const wasmUtils = getWasmUtils();
+    return wasmUtils.sealVault(suiteName, exportKey, clientIdentity, plaintext);
+}
+
+/**
+* openVault decrypts a vault blob created by sealVault. It rejects with ERR_AUTH_FAILED
+* when the export key or the client identity do not match.
+*/
+export const openVault = (exportKey: Uint8Array, clientIdentity: string, blob: Uint8Array): Promise<Uint8Array> => {
+    const wasmUtils = getWasmUtils();
+    return wasmUtils.openVault(exportKey, clientIdentity, blob);
+}
+
+/**
+* getSupportedSuites lists the suite names accepted by the client, server and utils functions.
+*/
+export const getSupportedSuites = (): Promise<Suite[]> => {
+    const wasmUtils = getWasmUtils();
+    return wasmUtils.getSupportedSuites();
+}